@@ -1,9 +1,13 @@
 package cmd
 
 import (
-	"log"
+	"os"
 
+	"github.com/gobuffalo/pop/v6"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/tranminhquanq/gomess/internal/config"
+	"github.com/tranminhquanq/gomess/internal/storage"
 )
 
 var migrateCmd = cobra.Command{
@@ -13,5 +17,34 @@ var migrateCmd = cobra.Command{
 }
 
 func migrate(cmd *cobra.Command, args []string) {
-	log.Panicln("migrate not implemented")
+	if err := config.LoadFile(configFile); err != nil {
+		logrus.WithError(err).Fatal("unable to load config")
+	}
+
+	globalConfig, err := config.LoadGlobalFromEnv()
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to load config")
+	}
+
+	db, err := storage.Dial(globalConfig)
+	if err != nil {
+		logrus.Fatalf("error opening database: %+v", err)
+	}
+	defer db.Close()
+
+	migrator, err := pop.NewFileMigrator(globalConfig.DB.MigrationsPath, db.Connection)
+	if err != nil {
+		logrus.Fatalf("error creating migrations: %+v", err)
+	}
+	// the schema is described by the migrations, do not dump it next to them
+	migrator.SchemaPath = ""
+
+	logrus.Infof("Running migrations from %s", globalConfig.DB.MigrationsPath)
+	if err := migrator.Up(); err != nil {
+		logrus.Fatalf("error running migrations: %+v", err)
+	}
+
+	if err := migrator.Status(os.Stdout); err != nil {
+		logrus.Fatalf("error showing migration status: %+v", err)
+	}
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/tranminhquanq/gomess/internal/app/handler"
+	"github.com/tranminhquanq/gomess/internal/broker"
 	"github.com/tranminhquanq/gomess/internal/config"
 	"github.com/tranminhquanq/gomess/internal/storage"
 	"github.com/tranminhquanq/gomess/internal/utils"
@@ -44,10 +45,16 @@ func serve(ctx context.Context) {
 	}
 	defer db.Close()
 
+	b, err := broker.Dial(globalConfig)
+	if err != nil {
+		logrus.Fatalf("error opening broker: %+v", err)
+	}
+	defer b.Close()
+
 	addr := net.JoinHostPort(globalConfig.API.Host, globalConfig.API.Port)
 	logrus.Infof("GoMess API started on: %s", addr)
 
-	opts := []handler.Option{}
+	opts := []handler.Option{handler.WithBroker(b)}
 	hdl := handler.NewHandlerWithVersion(globalConfig, db, utils.Version, opts...)
 
 	baseCtx, baseCancel := context.WithCancel(context.Background())
@@ -78,6 +85,12 @@ func serve(ctx context.Context) {
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Minute)
 		defer shutdownCancel()
 
+		// hijacked WebSocket connections and event streams are not
+		// waited for by the HTTP server, they are drained first
+		if err := hdl.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Warn("realtime connections not drained")
+		}
+
 		if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.Canceled) {
 			log.WithError(err).Error("shutdown failed")
 		}
@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+type Conversation struct {
+	ID             int64                   `json:"id"`
+	CreatorID      string                  `json:"creator_id"`
+	Title          string                  `json:"title"`
+	Type           models.ConversationType `json:"type"`
+	LastSeq        int64                   `json:"last_seq"`
+	ParticipantIDs []string                `json:"participant_ids"`
+	Participants   []Participant           `json:"participants"` // Participants with their role, oldest first
+	CreatedAt      time.Time               `json:"created_at"`
+	UpdatedAt      *time.Time              `json:"updated_at,omitempty"`
+}
+
+func (c Conversation) IsCreator(userId string) bool {
+	return c.CreatorID == userId
+}
+
+func (c Conversation) IsSingle() bool {
+	return c.Type == models.ConversationTypeSingle
+}
+
+func (c Conversation) IsGroup() bool {
+	return c.Type == models.ConversationTypeGroup
+}
+
+// Role returns the role of the user in the conversation, and false when the
+// user does not take part in it.
+func (c Conversation) Role(userId string) (models.ParticipantRole, bool) {
+	for _, participant := range c.Participants {
+		if participant.UserID == userId {
+			return participant.Role, true
+		}
+	}
+	return "", false
+}
+
+// HasParticipant reports whether the user takes part in the conversation.
+func (c Conversation) HasParticipant(userId string) bool {
+	for _, participantId := range c.ParticipantIDs {
+		if participantId == userId {
+			return true
+		}
+	}
+	return false
+}
+
+type Participant struct {
+	UserID   string                 `json:"user_id"`
+	Role     models.ParticipantRole `json:"role"`
+	JoinedAt time.Time              `json:"joined_at"`
+}
+
+// roleRanks orders the roles of the participants of a group.
+var roleRanks = map[models.ParticipantRole]int{
+	models.ParticipantRoleMember: 1,
+	models.ParticipantRoleAdmin:  2,
+	models.ParticipantRoleOwner:  3,
+}
+
+// Outranks reports whether role is above other.
+func Outranks(role, other models.ParticipantRole) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+// CanManageMembers reports whether the role lets a participant add users to
+// a group and rename it.
+func CanManageMembers(role models.ParticipantRole) bool {
+	return role == models.ParticipantRoleOwner || role == models.ParticipantRoleAdmin
+}
+
+type MembershipChange string
+
+const (
+	MembershipAdded                MembershipChange = "member_added"
+	MembershipRemoved              MembershipChange = "member_removed"
+	MembershipLeft                 MembershipChange = "member_left"
+	MembershipRoleChanged          MembershipChange = "role_changed"
+	MembershipOwnershipTransferred MembershipChange = "ownership_transferred"
+)
+
+// MembershipEvent describes a change to the participants of a group. Encoded
+// as JSON it is the body of the system message that records it in the
+// timeline, which it carries once stored.
+type MembershipEvent struct {
+	ConversationID int64                  `json:"conversation_id"`
+	Change         MembershipChange       `json:"change"`
+	ActorID        string                 `json:"actor_id"`
+	UserIDs        []string               `json:"user_ids"`
+	Role           models.ParticipantRole `json:"role,omitempty"`         // Role the users were given
+	NewOwnerID     string                 `json:"new_owner_id,omitempty"` // Participant promoted when the last owner left
+	Message        *Message               `json:"message,omitempty"`
+}
@@ -0,0 +1,25 @@
+package factory
+
+import (
+	"time"
+
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+type ConversationFactory struct{}
+
+func (c ConversationFactory) CreateConversation(
+	creatorId string,
+	conversationType models.ConversationType,
+	title string,
+	participantIds []string,
+) domain.Conversation {
+	return domain.Conversation{
+		CreatorID:      creatorId,
+		Type:           conversationType,
+		Title:          title,
+		ParticipantIDs: participantIds,
+		CreatedAt:      time.Now(),
+	}
+}
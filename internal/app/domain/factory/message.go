@@ -11,7 +11,7 @@ type MessageFactory struct{}
 func (m MessageFactory) CreateMessage(
 	id int64,
 	conversationId int64,
-	senderId int64,
+	senderId string,
 	message string,
 ) domain.Message {
 	return domain.Message{
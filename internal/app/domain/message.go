@@ -7,15 +7,31 @@ import (
 )
 
 type Message struct {
-	ID             int64              `json:"id"`
-	ConversationID int64              `json:"conversation_id"`
-	SenderID       int64              `json:"sender_id"`
-	Type           models.MessageType `json:"type"`
-	Message        string             `json:"message"`
-	CreatedAt      time.Time          `json:"created_at"`
-	UpdatedAt      *time.Time         `json:"updated_at,omitempty"`
+	ID              int64              `json:"id"`
+	ConversationID  int64              `json:"conversation_id"`
+	SenderID        string             `json:"sender_id"`
+	ClientMessageID string             `json:"client_message_id,omitempty"`
+	Seq             int64              `json:"seq"`
+	StreamSeq       int64              `json:"stream_seq"`
+	Type            models.MessageType `json:"type"`
+	Message         string             `json:"message"`
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       *time.Time         `json:"updated_at,omitempty"`
 
 	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Status and Receipts are filled in for the user listing the messages,
+	// Receipts only on the messages the user sent.
+	Status   MessageStatus    `json:"status,omitempty"`
+	Receipts *MessageReceipts `json:"receipts,omitempty"`
+}
+
+// MessageRevision is a text a message had before it was edited.
+type MessageRevision struct {
+	ID         int64     `json:"id"`
+	Message    string    `json:"message"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
 }
 
 type Attachment struct {
@@ -24,3 +40,20 @@ type Attachment struct {
 	URL       string                `json:"url"`
 	CreatedAt time.Time             `json:"created_at"`
 }
+
+// MessageCursor selects the messages of a conversation older than the
+// sequence number Before or newer than After. With neither set it selects the
+// latest messages.
+type MessageCursor struct {
+	Before int64
+	After  int64
+	Limit  int
+}
+
+// MessagePage is a page of the messages of a conversation, latest first.
+// HasOlder and HasNewer tell whether there are messages on either side of it.
+type MessagePage struct {
+	Items    []Message
+	HasOlder bool
+	HasNewer bool
+}
@@ -0,0 +1,45 @@
+package domain
+
+import "time"
+
+type PresenceStatus string
+
+const (
+	PresenceOnline       PresenceStatus = "online"
+	PresenceAway         PresenceStatus = "away"
+	PresenceDoNotDisturb PresenceStatus = "dnd"
+	PresenceInvisible    PresenceStatus = "invisible"
+
+	// PresenceOffline is never chosen, users without any open connection
+	// and invisible ones are seen offline.
+	PresenceOffline PresenceStatus = "offline"
+)
+
+// IsSelectable reports whether users may choose the status.
+func (s PresenceStatus) IsSelectable() bool {
+	switch s {
+	case PresenceOnline, PresenceAway, PresenceDoNotDisturb, PresenceInvisible:
+		return true
+	default:
+		return false
+	}
+}
+
+type Presence struct {
+	UserID     string         `json:"user_id"`
+	Status     PresenceStatus `json:"status"`
+	LastSeenAt *time.Time     `json:"last_seen_at,omitempty"`
+}
+
+// Visible returns the presence as other users see it, given whether the user
+// has an open connection on any instance. Invisible users do not give away
+// when they were last seen either.
+func (p Presence) Visible(connected bool) Presence {
+	if p.Status == PresenceInvisible {
+		p.LastSeenAt = nil
+	}
+	if !connected || p.Status == PresenceInvisible {
+		p.Status = PresenceOffline
+	}
+	return p
+}
@@ -0,0 +1,87 @@
+package domain
+
+type MessageStatus string
+
+const (
+	MessageStatusSent      MessageStatus = "sent"
+	MessageStatusDelivered MessageStatus = "delivered"
+	MessageStatusRead      MessageStatus = "read"
+)
+
+// Receipt tells up to which sequence number a participant got and read the
+// messages of a conversation.
+type Receipt struct {
+	ConversationID int64  `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+	DeliveredSeq   int64  `json:"delivered_seq"`
+	ReadSeq        int64  `json:"read_seq"`
+}
+
+// Status returns the status of the message with sequence number seq for the
+// participant of the receipt.
+func (r Receipt) Status(seq int64) MessageStatus {
+	switch {
+	case seq <= r.ReadSeq:
+		return MessageStatusRead
+	case seq <= r.DeliveredSeq:
+		return MessageStatusDelivered
+	default:
+		return MessageStatusSent
+	}
+}
+
+// MessageReceipts aggregates the receipts of the recipients of a message,
+// i.e. the participants other than its sender.
+type MessageReceipts struct {
+	Recipients  int `json:"recipients"`
+	DeliveredTo int `json:"delivered_to"`
+	ReadBy      int `json:"read_by"`
+}
+
+// AggregateReceipts counts how many recipients of message got and read it.
+func AggregateReceipts(message Message, receipts []Receipt) MessageReceipts {
+	var aggregate MessageReceipts
+	for _, receipt := range receipts {
+		if receipt.UserID == message.SenderID {
+			continue
+		}
+
+		aggregate.Recipients++
+		switch receipt.Status(message.Seq) {
+		case MessageStatusRead:
+			aggregate.ReadBy++
+			aggregate.DeliveredTo++
+		case MessageStatusDelivered:
+			aggregate.DeliveredTo++
+		}
+	}
+
+	return aggregate
+}
+
+// StatusFor returns the status of message as seen by userId. A recipient sees
+// its own state, the sender sees read once every recipient read it and
+// delivered once every recipient got it.
+func StatusFor(message Message, userId string, receipts []Receipt) MessageStatus {
+	if message.SenderID == userId {
+		aggregate := AggregateReceipts(message, receipts)
+		switch {
+		case aggregate.Recipients == 0:
+			// the sender is alone in the conversation
+		case aggregate.ReadBy == aggregate.Recipients:
+			return MessageStatusRead
+		case aggregate.DeliveredTo == aggregate.Recipients:
+			return MessageStatusDelivered
+		default:
+			return MessageStatusSent
+		}
+	}
+
+	for _, receipt := range receipts {
+		if receipt.UserID == userId {
+			return receipt.Status(message.Seq)
+		}
+	}
+
+	return MessageStatusSent
+}
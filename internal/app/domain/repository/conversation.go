@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+)
+
+type ConversationRepository interface {
+	CreateConversation(domain.Conversation) (domain.Conversation, error)
+	FindOrCreateDirectConversation(conversation domain.Conversation, peerId string) (domain.Conversation, bool, error)
+	FindConversationByID(id int64) (domain.Conversation, error)
+	FindConversationsOfUser(userId uuid.UUID, offset, limit int) (domain.ListResult[domain.Conversation], error)
+	UpdateConversationTitle(id int64, title string) (domain.Conversation, error)
+	DeleteConversation(id int64) error
+}
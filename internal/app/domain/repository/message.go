@@ -1,8 +1,21 @@
 package repository
 
-import "github.com/tranminhquanq/gomess/internal/app/domain"
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+)
 
 type MessageRepository interface {
 	SaveMessage(domain.Message) (domain.Message, error)
-	FindMessagesInConversation(conversationId int64, offset, limit int) (domain.ListResult[domain.Message], error)
+	UpdateMessage(id int64, message string, editedAt time.Time) (domain.Message, error)
+	FindMessageByID(id int64) (domain.Message, error)
+	FindMessageRevisions(messageId int64) ([]domain.MessageRevision, error)
+	FindMessageByClientMessageID(conversationId int64, senderId uuid.UUID, clientMessageId string) (domain.Message, error)
+	FindMessagesInConversation(conversationId int64, cursor domain.MessageCursor) (domain.MessagePage, error)
+	FindMessagesAfterSeq(conversationId int64, seq int64, limit int) ([]domain.Message, error)
+	FindMessageBySeq(conversationId int64, seq int64) (domain.Message, error)
+	FindSenderIDs(conversationId int64, afterSeq, uptoSeq int64) ([]uuid.UUID, error)
+	FindUserMessagesAfterStreamSeq(userId uuid.UUID, streamSeq int64, limit int) ([]domain.Message, error)
 }
@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+type ParticipantRepository interface {
+	IsParticipant(conversationId int64, userId uuid.UUID) (bool, error)
+	FindConversationIDs(userId uuid.UUID) ([]int64, error)
+	FindPeerIDs(userId uuid.UUID) ([]uuid.UUID, error)
+	FindParticipantIDs(conversationId int64) ([]uuid.UUID, error)
+	FindReceipts(conversationId int64) ([]domain.Receipt, error)
+	AddParticipants(conversationId int64, userIds []uuid.UUID, role models.ParticipantRole) ([]uuid.UUID, error)
+	RemoveParticipant(conversationId int64, actorId, userId uuid.UUID) error
+	UpdateParticipantRole(conversationId int64, actorId, userId uuid.UUID, role models.ParticipantRole) (bool, error)
+	TransferOwnership(conversationId int64, fromId, toId uuid.UUID) error
+	LeaveConversation(conversationId int64, userId uuid.UUID) (newOwnerId uuid.UUID, deleted bool, err error)
+	UpdateReceipt(conversationId int64, userId uuid.UUID, deliveredSeq, readSeq int64) (previous, current domain.Receipt, err error)
+}
@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+)
+
+type PresenceRepository interface {
+	FindPresences(userIds []uuid.UUID) ([]domain.Presence, error)
+	SaveStatus(userId uuid.UUID, status domain.PresenceStatus) (domain.Presence, error)
+	UpdateLastSeen(userId uuid.UUID, lastSeenAt time.Time) error
+}
@@ -0,0 +1,694 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/app/domain/factory"
+	"github.com/tranminhquanq/gomess/internal/app/usecase"
+	"github.com/tranminhquanq/gomess/internal/config"
+	"github.com/tranminhquanq/gomess/internal/models"
+	"github.com/tranminhquanq/gomess/internal/utils"
+)
+
+const (
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+
+	// maxConversationTitleLength is the size of the conversations.title
+	// column.
+	maxConversationTitleLength = 255
+)
+
+var (
+	conversationFactory = factory.ConversationFactory{}
+)
+
+type ConversationHandler struct {
+	globalConfig        *config.GlobalConfiguration
+	chatUsecase         *usecase.ChatUsecase
+	conversationUsecase *usecase.ConversationUsecase
+	wsHandler           *WsHandler // Pushes the realtime events caused by REST requests
+}
+
+func NewConversationHandler(
+	globalConfig *config.GlobalConfiguration,
+	chatUsecase *usecase.ChatUsecase,
+	conversationUsecase *usecase.ConversationUsecase,
+	wsHandler *WsHandler) *ConversationHandler {
+	return &ConversationHandler{
+		globalConfig:        globalConfig,
+		chatUsecase:         chatUsecase,
+		conversationUsecase: conversationUsecase,
+		wsHandler:           wsHandler,
+	}
+}
+
+type CreateConversationRequest struct {
+	Type           models.ConversationType `json:"type"`
+	Title          string                  `json:"title"`           // Required for groups, ignored for single conversations
+	ParticipantIDs []string                `json:"participant_ids"` // Users to add besides the creator
+}
+
+type CreateDirectConversationRequest struct {
+	UserID string `json:"user_id"` // The other user, or the caller for a conversation with themselves
+}
+
+type UpdateConversationRequest struct {
+	Title string `json:"title"`
+}
+
+type MessageRevisionsResponse struct {
+	Message   domain.Message           `json:"message"`   // Current text of the message
+	Revisions []domain.MessageRevision `json:"revisions"` // Previous texts, oldest first
+}
+
+type AddParticipantsRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+type UpdateParticipantRequest struct {
+	Role models.ParticipantRole `json:"role"` // admin or member
+}
+
+type TransferOwnershipRequest struct {
+	UserID string `json:"user_id"` // Participant becoming owner
+}
+
+type MarkReadRequest struct {
+	Seq int64 `json:"seq"` // Sequence number of the latest message read
+}
+
+type ReceiptsResponse struct {
+	ConversationID int64                   `json:"conversation_id"`
+	Seq            int64                   `json:"seq,omitempty"`     // Message the aggregate is about, when requested
+	Message        *domain.MessageReceipts `json:"message,omitempty"` // Read by N of M recipients of the message
+	Participants   []domain.Receipt        `json:"participants"`      // Receipt of every participant
+}
+
+// requireConversation returns the conversation of the request, making sure
+// the user of the request takes part in it. Conversations of others are
+// reported as not found.
+func (h *ConversationHandler) requireConversation(r *http.Request) (int64, string, error) {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return 0, "", err
+	}
+
+	ok, err := h.chatUsecase.IsParticipant(conversationId, userId)
+	if err != nil {
+		return 0, "", internalServerError("Error checking conversation participant").WithInternalError(err)
+	}
+	if !ok {
+		return 0, "", notFoundError(ErrorCodeConversationNotFound, "Conversation not found")
+	}
+
+	return conversationId, userId, nil
+}
+
+// GetConversations lists the conversations the user of the request takes part
+// in, latest first.
+func (h *ConversationHandler) GetConversations(w http.ResponseWriter, r *http.Request) error {
+	claims := getClaims(r.Context())
+	if claims == nil {
+		return internalServerError("No claims found in context")
+	}
+
+	page, limit := utils.ParsePagination(r)
+	if page < 1 || limit < 1 {
+		return badRequestError(ErrorCodeValidationFailed, "page and limit must be positive")
+	}
+
+	result, err := h.conversationUsecase.Conversations(claims.Subject, page, limit)
+	if err != nil {
+		return internalServerError("Error listing conversations").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, NewPaginationResponse(result.Items, NewPaginationMeta(result.Count, page, limit)))
+}
+
+// CreateConversation creates a single or group conversation between the user
+// of the request and the given participants.
+func (h *ConversationHandler) CreateConversation(w http.ResponseWriter, r *http.Request) error {
+	claims := getClaims(r.Context())
+	if claims == nil {
+		return internalServerError("No claims found in context")
+	}
+
+	params := &CreateConversationRequest{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	participantIds := []string{claims.Subject}
+	seen := map[string]bool{claims.Subject: true}
+	for _, participantId := range params.ParticipantIDs {
+		id, err := uuid.FromString(participantId)
+		if err != nil {
+			return badRequestError(ErrorCodeValidationFailed, "Invalid participant id %q", participantId)
+		}
+		if !seen[id.String()] {
+			seen[id.String()] = true
+			participantIds = append(participantIds, id.String())
+		}
+	}
+
+	title := strings.TrimSpace(params.Title)
+	switch params.Type {
+	case models.ConversationTypeSingle:
+		// the creator alone makes a conversation to keep notes in
+		if len(params.ParticipantIDs) == 0 || len(participantIds) > 2 {
+			return badRequestError(ErrorCodeValidationFailed, "A single conversation must have exactly one participant besides its creator, or the creator alone")
+		}
+		title = ""
+	case models.ConversationTypeGroup:
+		if title == "" {
+			return badRequestError(ErrorCodeValidationFailed, "A group conversation must have a title")
+		}
+		if len(title) > maxConversationTitleLength {
+			return badRequestError(ErrorCodeValidationFailed, "title must not be longer than %d characters", maxConversationTitleLength)
+		}
+		if len(participantIds) > usecase.MaxGroupParticipants {
+			return badRequestError(ErrorCodeValidationFailed, "A group conversation must not have more than %d participants", usecase.MaxGroupParticipants)
+		}
+	default:
+		return badRequestError(ErrorCodeValidationFailed, "type must be one of %q or %q", models.ConversationTypeSingle, models.ConversationTypeGroup)
+	}
+
+	conversation := conversationFactory.CreateConversation(claims.Subject, params.Type, title, participantIds)
+	conversation, created, err := h.conversationUsecase.CreateConversation(conversation)
+	if err != nil {
+		return internalServerError("Error creating conversation").WithInternalError(err)
+	}
+
+	return sendConversation(w, conversation, created)
+}
+
+// CreateDirectConversation returns the single conversation between the user
+// of the request and another user, creating it unless it exists. Users can
+// start one with themselves to keep notes.
+func (h *ConversationHandler) CreateDirectConversation(w http.ResponseWriter, r *http.Request) error {
+	claims := getClaims(r.Context())
+	if claims == nil {
+		return internalServerError("No claims found in context")
+	}
+
+	params := &CreateDirectConversationRequest{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	peerId, err := uuid.FromString(params.UserID)
+	if err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "Invalid user id %q", params.UserID)
+	}
+
+	conversation, created, err := h.conversationUsecase.DirectConversation(claims.Subject, peerId.String())
+	if err != nil {
+		return internalServerError("Error creating conversation").WithInternalError(err)
+	}
+
+	return sendConversation(w, conversation, created)
+}
+
+// sendConversation replies with a conversation that was created or, when it
+// existed already, found.
+func sendConversation(w http.ResponseWriter, conversation domain.Conversation, created bool) error {
+	if created {
+		return sendJSON(w, http.StatusCreated, conversation)
+	}
+	return sendJSON(w, http.StatusOK, conversation)
+}
+
+// GetConversation returns a conversation the user of the request takes part
+// in.
+func (h *ConversationHandler) GetConversation(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return err
+	}
+
+	conversation, err := h.conversationUsecase.Conversation(conversationId, userId)
+	if err != nil {
+		return conversationError(err, "Error getting conversation")
+	}
+
+	return sendJSON(w, http.StatusOK, conversation)
+}
+
+// UpdateConversation renames a group conversation.
+func (h *ConversationHandler) UpdateConversation(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return err
+	}
+
+	params := &UpdateConversationRequest{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	title := strings.TrimSpace(params.Title)
+	if title == "" {
+		return badRequestError(ErrorCodeValidationFailed, "title must not be empty")
+	}
+	if len(title) > maxConversationTitleLength {
+		return badRequestError(ErrorCodeValidationFailed, "title must not be longer than %d characters", maxConversationTitleLength)
+	}
+
+	conversation, err := h.conversationUsecase.RenameConversation(conversationId, userId, title)
+	if err != nil {
+		return conversationError(err, "Error renaming conversation")
+	}
+
+	return sendJSON(w, http.StatusOK, conversation)
+}
+
+// DeleteConversation deletes a conversation along with its messages.
+func (h *ConversationHandler) DeleteConversation(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return err
+	}
+
+	conversation, err := h.conversationUsecase.DeleteConversation(conversationId, userId)
+	if err != nil {
+		return conversationError(err, "Error deleting conversation")
+	}
+
+	return sendJSON(w, http.StatusOK, conversation)
+}
+
+// AddParticipants adds users to a group as members.
+func (h *ConversationHandler) AddParticipants(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return err
+	}
+
+	params := &AddParticipantsRequest{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if len(params.UserIDs) == 0 {
+		return badRequestError(ErrorCodeValidationFailed, "user_ids is required")
+	}
+
+	if len(params.UserIDs) > usecase.MaxGroupParticipants {
+		return badRequestError(ErrorCodeValidationFailed, "At most %d users can be added at once", usecase.MaxGroupParticipants)
+	}
+
+	for _, id := range params.UserIDs {
+		if _, err := uuid.FromString(id); err != nil {
+			return badRequestError(ErrorCodeValidationFailed, "Invalid user id %q", id)
+		}
+	}
+
+	event, err := h.conversationUsecase.AddParticipants(conversationId, userId, params.UserIDs)
+	if err != nil {
+		return conversationError(err, "Error adding participants")
+	}
+	h.wsHandler.pushMembership(event)
+
+	return sendJSON(w, http.StatusOK, event)
+}
+
+// UpdateParticipant changes the role of a participant of a group.
+func (h *ConversationHandler) UpdateParticipant(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return err
+	}
+
+	participantId, err := participantParam(r)
+	if err != nil {
+		return err
+	}
+
+	params := &UpdateParticipantRequest{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.Role != models.ParticipantRoleAdmin && params.Role != models.ParticipantRoleMember {
+		return badRequestError(ErrorCodeValidationFailed, "role must be one of %q or %q", models.ParticipantRoleAdmin, models.ParticipantRoleMember)
+	}
+
+	event, err := h.conversationUsecase.SetParticipantRole(conversationId, userId, participantId, params.Role)
+	if err != nil {
+		return conversationError(err, "Error changing participant role")
+	}
+	h.wsHandler.pushMembership(event)
+
+	return sendJSON(w, http.StatusOK, event)
+}
+
+// RemoveParticipant removes a participant from a group.
+func (h *ConversationHandler) RemoveParticipant(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return err
+	}
+
+	participantId, err := participantParam(r)
+	if err != nil {
+		return err
+	}
+
+	event, err := h.conversationUsecase.RemoveParticipant(conversationId, userId, participantId)
+	if err != nil {
+		return conversationError(err, "Error removing participant")
+	}
+	h.wsHandler.pushMembership(event)
+
+	return sendJSON(w, http.StatusOK, event)
+}
+
+// Leave takes the user of the request out of a group.
+func (h *ConversationHandler) Leave(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return err
+	}
+
+	event, err := h.conversationUsecase.LeaveConversation(conversationId, userId)
+	if err != nil {
+		return conversationError(err, "Error leaving conversation")
+	}
+	h.wsHandler.pushMembership(event)
+
+	return sendJSON(w, http.StatusOK, event)
+}
+
+// TransferOwnership hands the ownership of a group over to another
+// participant.
+func (h *ConversationHandler) TransferOwnership(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.conversationParams(r)
+	if err != nil {
+		return err
+	}
+
+	params := &TransferOwnershipRequest{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	participantId, err := uuid.FromString(params.UserID)
+	if err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "Invalid user id %q", params.UserID)
+	}
+
+	event, err := h.conversationUsecase.TransferOwnership(conversationId, userId, participantId.String())
+	if err != nil {
+		return conversationError(err, "Error transferring ownership")
+	}
+	h.wsHandler.pushMembership(event)
+
+	return sendJSON(w, http.StatusOK, event)
+}
+
+// participantParam returns the participant the request is about.
+func participantParam(r *http.Request) (string, error) {
+	id, err := uuid.FromString(chi.URLParam(r, "userId"))
+	if err != nil {
+		return "", badRequestError(ErrorCodeValidationFailed, "Invalid user id")
+	}
+
+	return id.String(), nil
+}
+
+// conversationParams returns the conversation of the request and the user of
+// the request, without checking that the user takes part in it.
+func (h *ConversationHandler) conversationParams(r *http.Request) (int64, string, error) {
+	claims := getClaims(r.Context())
+	if claims == nil {
+		return 0, "", internalServerError("No claims found in context")
+	}
+
+	conversationId, err := strconv.ParseInt(chi.URLParam(r, "conversationId"), 10, 64)
+	if err != nil || conversationId <= 0 {
+		return 0, "", badRequestError(ErrorCodeValidationFailed, "Invalid conversation id")
+	}
+
+	return conversationId, claims.Subject, nil
+}
+
+// conversationError turns the errors of the conversation usecase into HTTP
+// errors.
+func conversationError(err error, message string) error {
+	switch err.(type) {
+	case models.ParticipantNotFoundError, *models.ParticipantNotFoundError:
+		return notFoundError(ErrorCodeParticipantNotFound, "Participant not found")
+	}
+
+	switch {
+	case models.IsNotFoundError(err):
+		return notFoundError(ErrorCodeConversationNotFound, "Conversation not found")
+	case models.IsPermissionDeniedError(err):
+		return forbiddenError(ErrorCodeConversationForbidden, "%s", err)
+	default:
+		return internalServerError(message).WithInternalError(err)
+	}
+}
+
+// GetMessages lists the messages of a conversation, latest first, each with
+// its status for the user of the request. The before and after query
+// parameters page through older and newer messages than a sequence number.
+func (h *ConversationHandler) GetMessages(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.requireConversation(r)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := parseMessageCursor(r)
+	if err != nil {
+		return err
+	}
+
+	page, err := h.chatUsecase.GetChatHistory(conversationId, userId, cursor)
+	if err != nil {
+		return internalServerError("Error listing messages").WithInternalError(err)
+	}
+
+	meta := CursorPaginationMeta{
+		Limit:    cursor.Limit,
+		HasOlder: page.HasOlder,
+		HasNewer: page.HasNewer,
+	}
+	if n := len(page.Items); n > 0 {
+		meta.Before = page.Items[n-1].Seq
+		meta.After = page.Items[0].Seq
+	}
+
+	return sendJSON(w, http.StatusOK, NewCursorPaginationResponse(page.Items, meta))
+}
+
+// SendMessage sends a message to a conversation as the user of the request,
+// like the send_message action does. A retry with the same client message ID
+// returns the message sent the first time.
+func (h *ConversationHandler) SendMessage(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.requireConversation(r)
+	if err != nil {
+		return err
+	}
+
+	params := &SendMessageParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+	params.ConversationID = conversationId
+
+	if err := params.validate(); err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "%s", err)
+	}
+
+	saved, duplicate, err := h.wsHandler.sendMessage(userId, "", *params)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError(ErrorCodeConversationNotFound, "Conversation not found")
+		}
+		return internalServerError("Error sending message").WithInternalError(err)
+	}
+
+	if duplicate {
+		return sendJSON(w, http.StatusOK, saved)
+	}
+
+	return sendJSON(w, http.StatusCreated, saved)
+}
+
+// EditMessage replaces the text of a message the user of the request sent,
+// like the edit_message action does.
+func (h *ConversationHandler) EditMessage(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.requireConversation(r)
+	if err != nil {
+		return err
+	}
+
+	messageId, err := messageParam(r)
+	if err != nil {
+		return err
+	}
+
+	params := &EditMessageParams{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+	params.ConversationID = conversationId
+	params.MessageID = messageId
+
+	if err := params.validate(); err != nil {
+		return badRequestError(ErrorCodeValidationFailed, "%s", err)
+	}
+
+	edited, err := h.wsHandler.editMessage(userId, "", *params)
+	if err != nil {
+		switch {
+		case models.IsNotFoundError(err):
+			return notFoundError(ErrorCodeMessageNotFound, "Message not found")
+		case models.IsPermissionDeniedError(err):
+			return forbiddenError(ErrorCodeMessageNotEditable, "%s", err)
+		}
+		return internalServerError("Error editing message").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, edited)
+}
+
+// GetMessageRevisions returns a message of a conversation along with the
+// texts it had before it was edited, oldest first.
+func (h *ConversationHandler) GetMessageRevisions(w http.ResponseWriter, r *http.Request) error {
+	conversationId, _, err := h.requireConversation(r)
+	if err != nil {
+		return err
+	}
+
+	messageId, err := messageParam(r)
+	if err != nil {
+		return err
+	}
+
+	message, revisions, err := h.chatUsecase.MessageRevisions(conversationId, messageId)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError(ErrorCodeMessageNotFound, "Message not found")
+		}
+		return internalServerError("Error listing message revisions").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, MessageRevisionsResponse{
+		Message:   message,
+		Revisions: revisions,
+	})
+}
+
+// messageParam returns the message the request is about.
+func messageParam(r *http.Request) (int64, error) {
+	messageId, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil || messageId <= 0 {
+		return 0, badRequestError(ErrorCodeValidationFailed, "Invalid message id")
+	}
+
+	return messageId, nil
+}
+
+// parseMessageCursor reads the cursor of a page of messages from the before,
+// after and limit query parameters.
+func parseMessageCursor(r *http.Request) (domain.MessageCursor, error) {
+	query := r.URL.Query()
+	cursor := domain.MessageCursor{Limit: defaultMessagesLimit}
+
+	for name, seq := range map[string]*int64{"before": &cursor.Before, "after": &cursor.After} {
+		if s := query.Get(name); s != "" {
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || v <= 0 {
+				return domain.MessageCursor{}, badRequestError(ErrorCodeValidationFailed, "%s must be a positive integer", name)
+			}
+			*seq = v
+		}
+	}
+
+	if cursor.Before > 0 && cursor.After > 0 {
+		return domain.MessageCursor{}, badRequestError(ErrorCodeValidationFailed, "before and after cannot be used together")
+	}
+
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxMessagesLimit {
+			return domain.MessageCursor{}, badRequestError(ErrorCodeValidationFailed, "limit must be between 1 and %d", maxMessagesLimit)
+		}
+		cursor.Limit = limit
+	}
+
+	return cursor, nil
+}
+
+// MarkRead records that the user of the request read the messages of a
+// conversation up to a sequence number, and notifies their senders.
+func (h *ConversationHandler) MarkRead(w http.ResponseWriter, r *http.Request) error {
+	conversationId, userId, err := h.requireConversation(r)
+	if err != nil {
+		return err
+	}
+
+	params := &MarkReadRequest{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	if params.Seq <= 0 {
+		return badRequestError(ErrorCodeValidationFailed, "seq must be positive")
+	}
+
+	receipt, senders, err := h.chatUsecase.MarkRead(conversationId, userId, params.Seq)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return notFoundError(ErrorCodeConversationNotFound, "Conversation not found")
+		}
+		return internalServerError("Error marking conversation as read").WithInternalError(err)
+	}
+	h.wsHandler.pushReceipt(receipt, senders, "")
+
+	return sendJSON(w, http.StatusOK, receipt)
+}
+
+// GetReceipts returns the receipt of every participant of a conversation and,
+// given the seq query parameter, how many recipients got and read that
+// message.
+func (h *ConversationHandler) GetReceipts(w http.ResponseWriter, r *http.Request) error {
+	conversationId, _, err := h.requireConversation(r)
+	if err != nil {
+		return err
+	}
+
+	resp := ReceiptsResponse{ConversationID: conversationId}
+
+	if s := r.URL.Query().Get("seq"); s != "" {
+		seq, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || seq <= 0 {
+			return badRequestError(ErrorCodeValidationFailed, "seq must be a positive integer")
+		}
+
+		aggregate, err := h.chatUsecase.MessageReceipts(conversationId, seq)
+		if err != nil {
+			if models.IsNotFoundError(err) {
+				return notFoundError(ErrorCodeMessageNotFound, "Message not found")
+			}
+			return internalServerError("Error aggregating receipts").WithInternalError(err)
+		}
+		resp.Seq = seq
+		resp.Message = &aggregate
+	}
+
+	resp.Participants, err = h.chatUsecase.Receipts(conversationId)
+	if err != nil {
+		return internalServerError("Error listing receipts").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, resp)
+}
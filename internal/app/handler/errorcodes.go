@@ -92,4 +92,28 @@ const (
 	ErrorCodeInvalidCredentials        ErrorCode = "invalid_credentials"
 	ErrorCodeEmailAddressNotAuthorized ErrorCode = "email_address_not_authorized"
 	ErrorCodeEmailAddressInvalid       ErrorCode = "email_address_invalid"
+	ErrorCodeConversationNotFound      ErrorCode = "conversation_not_found"
+	ErrorCodeMessageNotFound           ErrorCode = "message_not_found"
+	ErrorCodeEventStreamNotFound       ErrorCode = "event_stream_not_found"
+	ErrorCodeServerShuttingDown        ErrorCode = "server_shutting_down"
+	ErrorCodeConversationForbidden     ErrorCode = "conversation_forbidden"
+	ErrorCodeParticipantNotFound       ErrorCode = "participant_not_found"
+	ErrorCodeMessageNotEditable        ErrorCode = "message_not_editable"
+)
+
+// WsErrorCode identifies the reason a WebSocket action failed. It is sent to
+// clients in WsError.Code.
+type WsErrorCode = int
+
+const (
+	WsErrorCodeBadJSON          WsErrorCode = 4000
+	WsErrorCodeValidationFailed WsErrorCode = 4001
+	WsErrorCodeUnknownAction    WsErrorCode = 4002
+	WsErrorCodeNotImplemented   WsErrorCode = 4003
+	WsErrorCodeForbidden        WsErrorCode = 4004
+	WsErrorCodeBadJWT           WsErrorCode = 4005
+	WsErrorCodeTokenExpired     WsErrorCode = 4006
+
+	WsErrorCodeOverRequestRateLimit WsErrorCode = 4029
+	WsErrorCodeUnexpectedFailure    WsErrorCode = 5000
 )
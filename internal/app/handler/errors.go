@@ -139,6 +139,10 @@ func forbiddenError(errorCode ErrorCode, fmtString string, args ...interface{})
 	return httpError(http.StatusForbidden, errorCode, fmtString, args...)
 }
 
+func notFoundError(errorCode ErrorCode, fmtString string, args ...interface{}) *HTTPError {
+	return httpError(http.StatusNotFound, errorCode, fmtString, args...)
+}
+
 func internalServerError(fmtString string, args ...interface{}) *HTTPError {
 	return httpError(http.StatusInternalServerError, ErrorCodeUnexpectedFailure, fmtString, args...)
 }
@@ -146,3 +150,23 @@ func internalServerError(fmtString string, args ...interface{}) *HTTPError {
 func badRequestError(errorCode ErrorCode, fmtString string, args ...interface{}) *HTTPError {
 	return httpError(http.StatusBadRequest, errorCode, fmtString, args...)
 }
+
+func (e *WsError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func (e *WsError) WithDetails(details string) *WsError {
+	e.Details = details
+	return e
+}
+
+func wsError(code WsErrorCode, fmtString string, args ...interface{}) *WsError {
+	return &WsError{
+		Code:    code,
+		Message: fmt.Sprintf(fmtString, args...),
+	}
+}
+
+func wsValidationError(fmtString string, args ...interface{}) *WsError {
+	return wsError(WsErrorCodeValidationFailed, fmtString, args...)
+}
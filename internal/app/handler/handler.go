@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/rs/cors"
@@ -8,7 +9,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/tranminhquanq/gomess/internal/app/repository"
 	"github.com/tranminhquanq/gomess/internal/app/usecase"
+	"github.com/tranminhquanq/gomess/internal/broker"
 	"github.com/tranminhquanq/gomess/internal/config"
+	"github.com/tranminhquanq/gomess/internal/notifier"
 	"github.com/tranminhquanq/gomess/internal/observability"
 	"github.com/tranminhquanq/gomess/internal/storage"
 )
@@ -22,9 +25,40 @@ type Option interface {
 	apply(*Handler)
 }
 
+type brokerOption struct {
+	broker broker.Broker
+}
+
+func (o brokerOption) apply(h *Handler) {
+	h.broker = o.broker
+}
+
+// WithBroker sets the broker used to fan out realtime events between
+// instances. An in-process broker is used when none is set.
+func WithBroker(b broker.Broker) Option {
+	return brokerOption{broker: b}
+}
+
+type notifierOption struct {
+	notifier notifier.Notifier
+}
+
+func (o notifierOption) apply(h *Handler) {
+	h.notifier = o.notifier
+}
+
+// WithNotifier sets where the events published to offline users go. They are
+// only logged when none is set.
+func WithNotifier(n notifier.Notifier) Option {
+	return notifierOption{notifier: n}
+}
+
 type Handler struct {
 	handler      http.Handler
 	db           *storage.Connection
+	broker       broker.Broker
+	notifier     notifier.Notifier
+	wsHandler    *WsHandler
 	globalConfig *config.GlobalConfiguration
 	version      string
 }
@@ -45,6 +79,18 @@ func NewHandlerWithVersion(
 		version:      version,
 	}
 
+	for _, o := range opt {
+		o.apply(api)
+	}
+
+	if api.broker == nil {
+		api.broker = broker.NewMemoryBroker()
+	}
+
+	if api.notifier == nil {
+		api.notifier = notifier.NewLogNotifier()
+	}
+
 	xffmw, _ := xff.Default()
 	logger := observability.NewStructuredLogger(logrus.StandardLogger(), globalConfig)
 
@@ -65,13 +111,22 @@ func NewHandlerWithVersion(
 	}
 
 	userRepository := repository.NewUserRepository(db)
+	participantRepository := repository.NewParticipantRepository(db)
+	messageRepository := repository.NewMessageRepository(db)
+	presenceRepository := repository.NewPresenceRepository(db)
+	conversationRepository := repository.NewConversationRepository(db)
 
-	chatUsecase := usecase.NewChatUsecase()
-	userUsecase := usecase.NewUserUsecase(userRepository)
+	chatUsecase := usecase.NewChatUsecase(participantRepository, messageRepository)
+	userUsecase := usecase.NewUserUsecase(userRepository, presenceRepository)
+	conversationUsecase := usecase.NewConversationUsecase(conversationRepository, participantRepository, messageRepository)
 
-	wsHandler := NewWsHandler(globalConfig, userUsecase, chatUsecase)
+	wsHandler := NewWsHandler(globalConfig, api.broker, userUsecase, chatUsecase, api.notifier)
+	api.wsHandler = wsHandler
 	authHandler := NewAuthHandler(globalConfig, userUsecase)
 	userHandler := NewUserHandler(globalConfig, userUsecase)
+	conversationHandler := NewConversationHandler(globalConfig, chatUsecase, conversationUsecase, wsHandler)
+	presenceHandler := NewPresenceHandler(globalConfig, chatUsecase, wsHandler)
+	publishHandler := NewPublishHandler(globalConfig, wsHandler)
 
 	r.Get("/health", api.HealthCheck)
 
@@ -89,18 +144,59 @@ func NewHandlerWithVersion(
 			r.Get("/{userId}", userHandler.GetUserDetails)
 			r.Get("/me", userHandler.GetCurrentUser)
 		})
+
+		r.With(api.requireAuthentication).Get("/presence", presenceHandler.GetPresences)
+
+		r.With(api.requireAuthentication).With(api.requireAdmin).Post("/publish", publishHandler.Publish)
+
+		r.Route("/events", func(r *router) {
+			r.Get("/", wsHandler.ServeEvents)
+			r.With(api.requireAuthentication).Post("/{clientId}", wsHandler.HandleEventAction)
+		})
+
+		r.With(api.requireAuthentication).Route("/conversations", func(r *router) {
+			r.Get("/", conversationHandler.GetConversations)
+			r.Post("/", conversationHandler.CreateConversation)
+			r.Post("/direct", conversationHandler.CreateDirectConversation)
+
+			r.Route("/{conversationId}", func(r *router) {
+				r.Get("/", conversationHandler.GetConversation)
+				r.Patch("/", conversationHandler.UpdateConversation)
+				r.Delete("/", conversationHandler.DeleteConversation)
+				r.Post("/participants", conversationHandler.AddParticipants)
+				r.Patch("/participants/{userId}", conversationHandler.UpdateParticipant)
+				r.Delete("/participants/{userId}", conversationHandler.RemoveParticipant)
+				r.Post("/leave", conversationHandler.Leave)
+				r.Post("/transfer", conversationHandler.TransferOwnership)
+				r.Get("/messages", conversationHandler.GetMessages)
+				r.Post("/messages", conversationHandler.SendMessage)
+				r.Patch("/messages/{messageId}", conversationHandler.EditMessage)
+				r.Get("/messages/{messageId}/revisions", conversationHandler.GetMessageRevisions)
+				r.Post("/read", conversationHandler.MarkRead)
+				r.Get("/receipts", conversationHandler.GetReceipts)
+			})
+		})
 	})
 
+	origins := newOriginValidator(globalConfig)
 	corsHandler := cors.New(cors.Options{
-		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
-		AllowedHeaders:   api.globalConfig.CORS.AllAllowedHeaders([]string{"Accept", "Authorization", "Content-Type", "X-Client-IP", "X-Client-Info", audHeaderName}),
+		AllowOriginFunc: func(origin string) bool {
+			return origins.allowed(origin, "http")
+		},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders:   api.globalConfig.CORS.AllAllowedHeaders([]string{"Accept", "Authorization", "Content-Type", "Last-Event-ID", "X-Client-IP", "X-Client-Info", audHeaderName}),
 		ExposedHeaders:   []string{"X-Total-Count"},
 		AllowCredentials: true,
 	})
 
+	// WebSocket upgrades bypass the router but still get a request ID
+	wsUpgrade := observability.AddRequestID(globalConfig)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		wsHandler.ServeWs(w, req)
+	}))
+
 	api.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if req.URL.Path == "/apiws" {
-			wsHandler.ServeWs(w, req)
+			wsUpgrade.ServeHTTP(w, req)
 		} else {
 			corsHandler.Handler(r).ServeHTTP(w, req)
 		}
@@ -109,6 +205,12 @@ func NewHandlerWithVersion(
 	return api
 }
 
+// Shutdown drains the realtime connections, which http.Server.Shutdown does not
+// wait for. It must be called before it.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	return h.wsHandler.Shutdown(ctx)
+}
+
 // ServeHTTP implements the http.Handler interface by passing the request along
 // to its underlying Handler.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
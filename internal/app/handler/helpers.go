@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 
+	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 	"github.com/tranminhquanq/gomess/internal/utils"
 )
@@ -21,6 +23,20 @@ func sendJSON(w http.ResponseWriter, status int, obj interface{}) error {
 	return err
 }
 
+// retrieveRequestParams decodes the JSON body of r into params.
+func retrieveRequestParams[A any](r *http.Request, params *A) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return internalServerError("Could not read request body").WithInternalError(err)
+	}
+
+	if err := json.Unmarshal(body, params); err != nil {
+		return badRequestError(ErrorCodeBadJSON, "Could not parse request body as JSON: %v", err)
+	}
+
+	return nil
+}
+
 func sendText(w http.ResponseWriter, status int, text string) error {
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(status)
@@ -65,6 +81,28 @@ func NewPaginationResponse[T any](data T, meta PaginationMeta) PaginationRespons
 	}
 }
 
+// CursorPaginationMeta describes a page of a list paginated by cursor, latest
+// first. Before and After are the cursors of the older and newer pages.
+type CursorPaginationMeta struct {
+	Limit    int   `json:"limit"`
+	HasOlder bool  `json:"has_older"`
+	HasNewer bool  `json:"has_newer"`
+	Before   int64 `json:"before,omitempty"`
+	After    int64 `json:"after,omitempty"`
+}
+
+type CursorPaginationResponse[T any] struct {
+	Data T                    `json:"data"`
+	Meta CursorPaginationMeta `json:"meta"`
+}
+
+func NewCursorPaginationResponse[T any](data T, meta CursorPaginationMeta) CursorPaginationResponse[T] {
+	return CursorPaginationResponse[T]{
+		Data: data,
+		Meta: meta,
+	}
+}
+
 func WsSuccessResponse(action WsAction, data interface{}) *WsResponse {
 	return &WsResponse{
 		Version:   "1.0",
@@ -89,6 +127,20 @@ func WsErrorResponse(action WsAction, code int, message, details string) *WsResp
 	}
 }
 
+// WsEventResponse builds an event pushed by the server rather than sent in
+// reply to a WsMessage. Each event gets its own ID so that clients can tell it
+// apart from replies.
+func WsEventResponse(action WsAction, data interface{}) *WsResponse {
+	return &WsResponse{
+		Version:   "1.0",
+		Status:    "event",
+		Action:    action,
+		EventID:   uuid.Must(uuid.NewV4()).String(),
+		Timestamp: utils.CurrentTimestamp("seconds"),
+		Data:      data,
+	}
+}
+
 func (h *UserHandler) requestAud(ctx context.Context, r *http.Request) string {
 	if aud := r.Header.Get(audHeaderName); aud != "" {
 		return aud
@@ -89,6 +89,13 @@ func (t *timeoutResponseWriter) finallyWrite(w http.ResponseWriter) {
 func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// streams outlive any timeout and must reach the client as
+			// they are written rather than once served
+			if isStreamingRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			ctx, cancel := context.WithTimeout(r.Context(), timeout)
 			defer cancel()
 
@@ -154,17 +161,28 @@ func extractBearerToken(r *http.Request) (string, error) {
 func (h *Handler) parseJWTClaims(tokenString string, r *http.Request) (context.Context, error) {
 	ctx := r.Context()
 
-	p := jwt.NewParser(jwt.WithValidMethods(h.globalConfig.JWT.ValidMethods))
+	token, err := parseAccessToken(h.globalConfig, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return withToken(ctx, token), nil
+}
+
+// parseAccessToken parses and verifies an access token signed with one of the
+// configured JWT keys.
+func parseAccessToken(globalConfig *config.GlobalConfiguration, tokenString string) (*jwt.Token, error) {
+	p := jwt.NewParser(jwt.WithValidMethods(globalConfig.JWT.ValidMethods))
 	token, err := p.ParseWithClaims(tokenString, &AccessTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if kid, ok := token.Header["kid"]; ok {
 			if kidStr, ok := kid.(string); ok {
-				return config.FindPublicKeyByKid(kidStr, &h.globalConfig.JWT)
+				return config.FindPublicKeyByKid(kidStr, &globalConfig.JWT)
 			}
 		}
 		if alg, ok := token.Header["alg"]; ok {
 			if alg == jwt.SigningMethodHS256.Name {
 				// preserve backward compatibility for cases where the kid is not set
-				return []byte(h.globalConfig.JWT.Secret), nil
+				return []byte(globalConfig.JWT.Secret), nil
 			}
 		}
 		return nil, fmt.Errorf("missing kid")
@@ -174,7 +192,25 @@ func (h *Handler) parseJWTClaims(tokenString string, r *http.Request) (context.C
 		return nil, forbiddenError(ErrorCodeBadJWT, "invalid JWT: unable to parse or verify signature, %v", err).WithInternalError(err)
 	}
 
-	return withToken(ctx, token), nil
+	return token, nil
+}
+
+// requireAdmin only lets through the requests whose token has one of the
+// configured admin roles, e.g. those of backend services. None are configured
+// by default, which lets nobody through.
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	claims := getClaims(r.Context())
+	if claims == nil {
+		return nil, internalServerError("No claims found in context")
+	}
+
+	for _, role := range h.globalConfig.JWT.AdminRoles {
+		if claims.Role == role {
+			return nil, nil
+		}
+	}
+
+	return nil, forbiddenError(ErrorCodeNotAdmin, "User not allowed")
 }
 
 // requireAuthentication checks incoming requests for tokens presented using the Authorization header
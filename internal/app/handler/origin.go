@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/config"
+	"github.com/tranminhquanq/gomess/internal/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// originValidator checks the Origin header of browser requests against the
+// allowed origins, logging and counting the rejected ones.
+type originValidator struct {
+	globalConfig *config.GlobalConfiguration
+	rejected     metric.Int64Counter
+}
+
+func newOriginValidator(globalConfig *config.GlobalConfiguration) *originValidator {
+	meter := otel.Meter("gomess")
+	rejected, err := meter.Int64Counter(
+		"rejected_origins",
+		metric.WithDescription("Number of requests rejected because of their Origin header"),
+	)
+	if err != nil {
+		logrus.WithError(err).Error("unable to get gomess.rejected_origins counter metric")
+	}
+
+	return &originValidator{
+		globalConfig: globalConfig,
+		rejected:     rejected,
+	}
+}
+
+// allowed reports whether origin may access the API over transport, either
+// http or websocket.
+func (v *originValidator) allowed(origin, transport string) bool {
+	if utils.IsOriginAllowed(v.globalConfig, origin) {
+		return true
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"origin":    origin,
+		"transport": transport,
+	}).Warn("Rejected request from disallowed origin")
+
+	if v.rejected != nil {
+		v.rejected.Add(context.Background(), 1, metric.WithAttributes(attribute.String("transport", transport)))
+	}
+
+	return false
+}
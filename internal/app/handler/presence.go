@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/app/usecase"
+	"github.com/tranminhquanq/gomess/internal/config"
+)
+
+// maxPresenceLookup is the number of users whose presence can be looked up at
+// once.
+const maxPresenceLookup = 100
+
+type PresenceHandler struct {
+	globalConfig *config.GlobalConfiguration
+	chatUsecase  *usecase.ChatUsecase
+	wsHandler    *WsHandler // Knows which users are connected across instances
+}
+
+func NewPresenceHandler(
+	globalConfig *config.GlobalConfiguration,
+	chatUsecase *usecase.ChatUsecase,
+	wsHandler *WsHandler) *PresenceHandler {
+	return &PresenceHandler{
+		globalConfig: globalConfig,
+		chatUsecase:  chatUsecase,
+		wsHandler:    wsHandler,
+	}
+}
+
+// GetPresences returns the presence of the users listed, comma separated, in
+// the user_ids query parameter. Only the caller and the users sharing a
+// conversation with them are looked up, the others are left out.
+func (h *PresenceHandler) GetPresences(w http.ResponseWriter, r *http.Request) error {
+	claims := getClaims(r.Context())
+	if claims == nil {
+		return internalServerError("No claims found in context")
+	}
+
+	var userIds []string
+	for _, userId := range strings.Split(r.URL.Query().Get("user_ids"), ",") {
+		userId = strings.TrimSpace(userId)
+		if userId == "" {
+			continue
+		}
+
+		id, err := uuid.FromString(userId)
+		if err != nil {
+			return badRequestError(ErrorCodeValidationFailed, "Invalid user id %q", userId)
+		}
+		userIds = append(userIds, id.String())
+	}
+
+	if len(userIds) == 0 {
+		return badRequestError(ErrorCodeValidationFailed, "user_ids is required")
+	}
+
+	if len(userIds) > maxPresenceLookup {
+		return badRequestError(ErrorCodeValidationFailed, "At most %d users can be looked up at once", maxPresenceLookup)
+	}
+
+	peerIds, err := h.chatUsecase.PeerIDs(claims.Subject)
+	if err != nil {
+		return internalServerError("Error finding conversation peers").WithInternalError(err)
+	}
+
+	visible := make(map[string]struct{}, len(peerIds)+1)
+	visible[claims.Subject] = struct{}{}
+	for _, peerId := range peerIds {
+		visible[peerId] = struct{}{}
+	}
+
+	userIds = slices.DeleteFunc(userIds, func(userId string) bool {
+		_, ok := visible[userId]
+		return !ok
+	})
+	if len(userIds) == 0 {
+		return sendJSON(w, http.StatusOK, []domain.Presence{})
+	}
+
+	presences, err := h.wsHandler.visiblePresences(r.Context(), userIds)
+	if err != nil {
+		return internalServerError("Error looking up presences").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, presences)
+}
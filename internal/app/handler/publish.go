@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/config"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+const (
+	// maxPublishRecipients is the number of users an event can be
+	// published to at once.
+	maxPublishRecipients = 1000
+
+	// maxEventNameLength is the length of the longest event name.
+	maxEventNameLength = 100
+)
+
+type PublishHandler struct {
+	globalConfig *config.GlobalConfiguration
+	wsHandler    *WsHandler
+}
+
+func NewPublishHandler(
+	globalConfig *config.GlobalConfiguration,
+	wsHandler *WsHandler) *PublishHandler {
+	return &PublishHandler{
+		globalConfig: globalConfig,
+		wsHandler:    wsHandler,
+	}
+}
+
+// PublishRequest publishes an event to a user, a list of users or a
+// conversation. Only one of them may be set.
+type PublishRequest struct {
+	UserID         string          `json:"user_id"`
+	UserIDs        []string        `json:"user_ids"`
+	ConversationID int64           `json:"conversation_id"`
+	Event          string          `json:"event"` // Name of the event, e.g. export_ready
+	Data           json.RawMessage `json:"data"`
+}
+
+// Publish lets backend services push an event to users over their live
+// connections, and to the notification pipeline for those offline.
+func (h *PublishHandler) Publish(w http.ResponseWriter, r *http.Request) error {
+	params := &PublishRequest{}
+	if err := retrieveRequestParams(r, params); err != nil {
+		return err
+	}
+
+	event := strings.TrimSpace(params.Event)
+	if event == "" {
+		return badRequestError(ErrorCodeValidationFailed, "event is required")
+	}
+	if len(event) > maxEventNameLength {
+		return badRequestError(ErrorCodeValidationFailed, "event must not be longer than %d characters", maxEventNameLength)
+	}
+
+	var target PublishTarget
+	targets := 0
+	if params.UserID != "" {
+		target.UserIDs = []string{params.UserID}
+		targets++
+	}
+	if len(params.UserIDs) > 0 {
+		target.UserIDs = params.UserIDs
+		targets++
+	}
+	if params.ConversationID != 0 {
+		target.ConversationID = params.ConversationID
+		targets++
+	}
+
+	if targets != 1 {
+		return badRequestError(ErrorCodeValidationFailed, "Exactly one of user_id, user_ids and conversation_id is required")
+	}
+
+	if params.ConversationID < 0 {
+		return badRequestError(ErrorCodeValidationFailed, "Invalid conversation id")
+	}
+
+	if len(target.UserIDs) > maxPublishRecipients {
+		return badRequestError(ErrorCodeValidationFailed, "An event can be published to at most %d users at once", maxPublishRecipients)
+	}
+
+	seen := make(map[string]bool, len(target.UserIDs))
+	userIds := make([]string, 0, len(target.UserIDs))
+	for _, userId := range target.UserIDs {
+		id, err := uuid.FromString(userId)
+		if err != nil {
+			return badRequestError(ErrorCodeValidationFailed, "Invalid user id %q", userId)
+		}
+		if !seen[id.String()] {
+			seen[id.String()] = true
+			userIds = append(userIds, id.String())
+		}
+	}
+	target.UserIDs = userIds
+
+	result, err := h.wsHandler.Publish(r.Context(), target, ServerEvent{Event: event, Data: params.Data})
+	if models.IsNotFoundError(err) {
+		return notFoundError(ErrorCodeConversationNotFound, "Conversation not found")
+	}
+	if err != nil {
+		return internalServerError("Error publishing event").WithInternalError(err)
+	}
+
+	return sendJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/utils"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sseEventsPath is the endpoint streaming the realtime events as Server-Sent
+// Events, for clients behind proxies that do not let WebSocket upgrades
+// through.
+const sseEventsPath = "/api/events"
+
+// EventStreamConnected is the first event of an event stream. Its client ID
+// addresses the actions of the stream.
+type EventStreamConnected struct {
+	ClientID string `json:"client_id"`
+}
+
+// sseTransport writes to a Server-Sent Events stream. Every message is an event
+// whose data is the JSON encoded WsResponse. Events carrying a message get its
+// stream sequence number as event ID, which the client sends back as
+// Last-Event-ID to resume the stream after a reconnect.
+type sseTransport struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (t *sseTransport) WriteMessage(message *wsOutbound, data []byte, deadline time.Time) error {
+	var buf bytes.Buffer
+	if message.cursor > 0 {
+		fmt.Fprintf(&buf, "id: %d\n", message.cursor)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	return t.write(buf.Bytes(), deadline)
+}
+
+func (t *sseTransport) WritePing(deadline time.Time) error {
+	return t.write([]byte(": ping\n\n"), deadline)
+}
+
+// WriteClose sends a close event, which EventSource only dispatches to the
+// listeners of "close", so that the client does not reconnect on its own.
+func (t *sseTransport) WriteClose(code int, text string, deadline time.Time) error {
+	data, err := json.Marshal(map[string]interface{}{"code": code, "reason": text})
+	if err != nil {
+		return err
+	}
+
+	return t.write([]byte(fmt.Sprintf("event: close\ndata: %s\n\n", data)), deadline)
+}
+
+func (t *sseTransport) Close() error {
+	if err := t.rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+func (t *sseTransport) write(b []byte, deadline time.Time) error {
+	if err := t.rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	if _, err := t.w.Write(b); err != nil {
+		return err
+	}
+
+	return t.rc.Flush()
+}
+
+// ServeEvents streams the realtime events of the user as Server-Sent Events.
+// The access token is taken as for WebSocket upgrades, and the events are the
+// WsResponse envelopes a WebSocket client would get. The client sends its
+// actions to HandleEventAction. Given a Last-Event-ID the stream resumes every
+// conversation of the user after that stream sequence number.
+func (h *WsHandler) ServeEvents(w http.ResponseWriter, r *http.Request) error {
+	if err := h.rejectWhileDraining(w); err != nil {
+		return err
+	}
+
+	claims, err := h.authenticateWs(r)
+	if err != nil {
+		return err
+	}
+
+	lastEventId, err := sseLastEventID(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.userUsecase.UserDetails(claims.Subject)
+	if err != nil {
+		return httpError(http.StatusUnauthorized, ErrorCodeUserNotFound, "Could not authenticate user").WithInternalError(err)
+	}
+
+	rc := http.NewResponseController(w)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// the response has started, errors can only be logged from now on
+	if err := rc.Flush(); err != nil {
+		logrus.WithError(err).Error("Error flushing event stream")
+		return nil
+	}
+
+	client := newClient(&sseTransport{w: w, rc: rc}, user, jsonCodec, &h.globalConfig.API.WebSocket, h.metrics)
+	client.requestID = utils.GetRequestID(r.Context())
+	client.upgradeSpan = trace.SpanContextFromContext(r.Context())
+	if claims.ExpiresAt != nil {
+		client.expireTokenAt(&claims.ExpiresAt.Time)
+	}
+
+	h.registerClient(r.Context(), client)
+	defer h.handleCloseConnection(client)
+
+	client.sendResponse(WsEventResponse(ActionConnected, EventStreamConnected{ClientID: client.ID}))
+
+	if lastEventId != nil {
+		go h.resumeEventStream(client, *lastEventId)
+	}
+
+	go func() {
+		select {
+		case <-r.Context().Done():
+			// the peer is gone, nothing can be written anymore
+			client.close(websocket.CloseAbnormalClosure, "closed by peer")
+		case <-client.done:
+		}
+	}()
+
+	client.writePump()
+
+	return nil
+}
+
+// resumeEventStream replays the messages an event stream missed after the
+// stream sequence number cursor and pushes the reply, as if the client had sent a
+// resume action.
+func (h *WsHandler) resumeEventStream(client *WsClient, cursor int64) {
+	params, err := json.Marshal(ResumeParams{Cursor: &cursor})
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding resume parameters")
+		return
+	}
+
+	msg := WsMessage{Action: ActionResume, Parameters: params, codec: client.codec}
+	resp, _ := h.handleMessage(client, &msg)
+	client.sendResponse(resp)
+}
+
+// HandleEventAction runs an action, a WsMessage encoded as JSON, for the event
+// stream identified by the clientId URL parameter and replies with its
+// WsResponse. The request must reach the instance serving the stream.
+func (h *WsHandler) HandleEventAction(w http.ResponseWriter, r *http.Request) error {
+	claims := getClaims(r.Context())
+	if claims == nil {
+		return internalServerError("No claims found in context")
+	}
+
+	// WebSocket clients and the streams of other users are not found
+	client := h.localClients.client(chi.URLParam(r, "clientId"))
+	if client == nil || client.Conn != nil || client.UserID != claims.Subject {
+		return notFoundError(ErrorCodeEventStreamNotFound, "Event stream not found")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, client.config.MaxMessageSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.limiter.violated(client, "", wsLimitMessageSize)
+			return httpError(http.StatusRequestEntityTooLarge, ErrorCodeValidationFailed, "Message must not be larger than %d bytes", maxBytesErr.Limit)
+		}
+		return internalServerError("Could not read request body").WithInternalError(err)
+	}
+	client.touch()
+
+	resp, closeConn := h.dispatch(client, body)
+	defer resp.sent()
+	if closeConn {
+		client.close(websocket.CloseNormalClosure, "")
+	}
+
+	status := http.StatusOK
+	if resp.Error != nil && resp.Error.Code == WsErrorCodeOverRequestRateLimit {
+		status = http.StatusTooManyRequests
+	}
+
+	return sendJSON(w, status, resp)
+}
+
+// sseLastEventID returns the ID of the last event the client got before it
+// reconnected, nil on a first connection. Clients that cannot set the header
+// pass it in the last_event_id query parameter.
+func sseLastEventID(r *http.Request) (*int64, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	if s == "" {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return nil, badRequestError(ErrorCodeValidationFailed, "Invalid Last-Event-ID %q", s)
+	}
+
+	return &id, nil
+}
+
+// isStreamingRequest reports whether r is served by an endpoint that streams
+// its response for as long as the client stays connected. The router serves
+// the endpoint with or without a trailing slash.
+func isStreamingRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.TrimRight(r.URL.Path, "/") == sseEventsPath
+}
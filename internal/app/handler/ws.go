@@ -1,30 +1,61 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"net"
 	"net/http"
-	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
-	"github.com/tranminhquanq/gomess/internal/app/domain"
 	"github.com/tranminhquanq/gomess/internal/app/usecase"
+	"github.com/tranminhquanq/gomess/internal/broker"
 	"github.com/tranminhquanq/gomess/internal/config"
+	"github.com/tranminhquanq/gomess/internal/notifier"
+	"github.com/tranminhquanq/gomess/internal/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("gomess")
+
 type WsAction string
 
 const (
-	ActionSubscribe     WsAction = "subscribe"
-	ActionSendMessage   WsAction = "send_message"
-	ActionUpdateProfile WsAction = "update_profile"
-	ActionDisconnect    WsAction = "disconnect"
+	ActionSubscribe      WsAction = "subscribe"
+	ActionUnsubscribe    WsAction = "unsubscribe"
+	ActionSendMessage    WsAction = "send_message"
+	ActionUpdateProfile  WsAction = "update_profile"
+	ActionDisconnect     WsAction = "disconnect"
+	ActionReauthenticate WsAction = "reauthenticate"
+	ActionAck            WsAction = "ack"
+	ActionResume         WsAction = "resume"
+	ActionTypingStart    WsAction = "typing_start"
+	ActionTypingStop     WsAction = "typing_stop"
+	ActionMarkRead       WsAction = "mark_read"
+	ActionReceipt        WsAction = "receipt"
+	ActionSetPresence    WsAction = "set_presence"
+	ActionPresence       WsAction = "presence"
+	ActionConnected      WsAction = "connected"
+	ActionGoingAway      WsAction = "going_away"
+	ActionServerEvent    WsAction = "server_event"
+	ActionMembership     WsAction = "membership"
+	ActionEditMessage    WsAction = "edit_message"
+	ActionMessageEdited  WsAction = "message_edited"
 )
 
 type WsMessage struct {
-	Version    string      `json:"version"`    // Version of the protocol or API
-	Action     WsAction    `json:"action"`     // Action type the message corresponds to
-	Timestamp  int64       `json:"timestamp"`  // Client's timestamp for the message. Format: Unix timestamp in milliseconds
-	Parameters interface{} `json:"parameters"` // Parameters for the action
+	ID         string       `json:"id,omitempty"` // Client-supplied ID echoed in the response, to match replies to requests
+	Version    string       `json:"version"`      // Version of the protocol or API
+	Action     WsAction     `json:"action"`       // Action type the message corresponds to
+	Timestamp  int64        `json:"timestamp"`    // Client's timestamp for the message. Format: Unix timestamp in milliseconds
+	Parameters WsParameters `json:"parameters"`   // Parameters for the action, decoded by the action handler
+
+	codec wsCodec // Codec the message was decoded with, which also decodes its parameters
 }
 
 type WsError struct {
@@ -34,83 +65,224 @@ type WsError struct {
 }
 
 type WsResponse struct {
-	Version   string      `json:"version"`   // Version of the protocol or API
-	Status    string      `json:"status"`    // Response status (e.g., "success", "error")
-	Action    WsAction    `json:"action"`    // Action type the response corresponds to
-	Timestamp int64       `json:"timestamp"` // Server's timestamp for the response
-	Data      interface{} `json:"data"`      // Data payload for the response
-	Error     *WsError    `json:"error"`     // Error details if status is "error"
+	ID        string      `json:"id,omitempty"`       // ID of the WsMessage the response replies to
+	EventID   string      `json:"event_id,omitempty"` // ID of an event pushed by the server, unset on replies
+	Version   string      `json:"version"`            // Version of the protocol or API
+	Status    string      `json:"status"`             // Response status: "success" or "error" for replies, "event" for pushes
+	Action    WsAction    `json:"action"`             // Action type the response corresponds to
+	Timestamp int64       `json:"timestamp"`          // Server's timestamp for the response
+	Data      interface{} `json:"data"`               // Data payload for the response
+	Error     *WsError    `json:"error"`              // Error details if status is "error"
+
+	afterSend func() // Called once the response is queued or written, see wsReply
 }
 
-type WsClient struct {
-	ID   string
-	Conn *websocket.Conn
-	User domain.User
+// sent runs what was deferred until the response is sent.
+func (r *WsResponse) sent() {
+	if r.afterSend != nil {
+		r.afterSend()
+	}
 }
 
+// wsActionHandler handles a single WsMessage sent by client. The returned data
+// is sent back in a WsSuccessResponse, a returned error is sent back in a
+// WsErrorResponse.
+type wsActionHandler func(client *WsClient, msg *WsMessage) (interface{}, error)
+
+// wsReply can be returned by a wsActionHandler as data for afterSend to be
+// called once the response carrying data is sent, e.g. to push events that
+// must follow it.
+type wsReply struct {
+	data      interface{}
+	afterSend func()
+}
+
+// errWsCloseConnection can be returned by a wsActionHandler to close the
+// connection once the response has been sent.
+var errWsCloseConnection = errors.New("close websocket connection")
+
 type WsHandler struct {
-	serverId string
-	// redisClient *redis.Client
-	localClients sync.Map
+	globalConfig *config.GlobalConfiguration
+	serverId     string
+	broker       broker.Broker
+	localClients *localClientRegistry
+	channels     *channelRegistry
+	typing       *typingTracker
+	limiter      *wsRateLimiter
+	metrics      *wsMetrics
+	notifier     notifier.Notifier
 	upgrader     websocket.Upgrader
+	actions      map[WsAction]wsActionHandler
 	userUsecase  *usecase.UserUsecase
 	chatUsecase  *usecase.ChatUsecase
+
+	// draining is set once the server shuts down, new connections are
+	// rejected from then on.
+	draining atomic.Bool
 }
 
 // NewWsHandler creates a new WebSocket handler
 func NewWsHandler(
 	globalConfig *config.GlobalConfiguration,
+	broker broker.Broker,
 	userUsecase *usecase.UserUsecase,
 	chatUsecase *usecase.ChatUsecase,
+	notifier notifier.Notifier,
 ) *WsHandler {
-	return &WsHandler{
-		serverId: globalConfig.API.ID,
-		// redisClient: redisClient,
-		localClients: sync.Map{},
+	h := &WsHandler{
+		globalConfig: globalConfig,
+		serverId:     globalConfig.API.ID,
+		broker:       broker,
+		localClients: newLocalClientRegistry(),
+		typing:       newTypingTracker(),
+		limiter:      newWsRateLimiter(&globalConfig.API.WebSocket),
+		metrics:      newWsMetrics(),
+		notifier:     notifier,
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				// Allow requests from any origin
-				return true
-			},
+			Subprotocols:    wsSubprotocols(),
+
+			EnableCompression: globalConfig.API.WebSocket.Compression,
 		},
+		actions:     make(map[WsAction]wsActionHandler),
 		userUsecase: userUsecase,
 		chatUsecase: chatUsecase,
 	}
+	h.channels = newChannelRegistry(h.subscribeToChannel, h.unsubscribeFromChannel)
+
+	origins := newOriginValidator(globalConfig)
+	h.upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// only browsers send the Origin header, and only browsers can
+			// be tricked into opening a connection from another site
+			return true
+		}
+		return origins.allowed(origin, "websocket")
+	}
+
+	// every instance listens on its own topic for the events addressed to
+	// the clients connected to it
+	if err := h.broker.Subscribe(context.Background(), serverTopic(h.serverId), h.handleServerEvent); err != nil {
+		logrus.WithError(err).Error("Error subscribing to the server topic")
+	}
+
+	h.registerAction(ActionSubscribe, h.handleSubscribe)
+	h.registerAction(ActionUnsubscribe, h.handleUnsubscribe)
+	h.registerAction(ActionSendMessage, h.handleSendMessage)
+	h.registerAction(ActionUpdateProfile, h.handleUpdateProfile)
+	h.registerAction(ActionDisconnect, h.handleDisconnect)
+	h.registerAction(ActionReauthenticate, h.handleReauthenticate)
+	h.registerAction(ActionAck, h.handleAck)
+	h.registerAction(ActionResume, h.handleResume)
+	h.registerAction(ActionTypingStart, h.handleTypingStart)
+	h.registerAction(ActionTypingStop, h.handleTypingStop)
+	h.registerAction(ActionMarkRead, h.handleMarkRead)
+	h.registerAction(ActionSetPresence, h.handleSetPresence)
+	h.registerAction(ActionEditMessage, h.handleEditMessage)
+
+	return h
 }
 
-// ServeWs handles WebSocket connections
+// registerAction routes every incoming WsMessage with the given action to fn.
+func (h *WsHandler) registerAction(action WsAction, fn wsActionHandler) {
+	h.actions[action] = fn
+}
+
+// ServeWs handles WebSocket connections. The access token of the request is
+// verified before the connection is upgraded.
 func (h *WsHandler) ServeWs(w http.ResponseWriter, r *http.Request) error {
-	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err := h.rejectWhileDraining(w); err != nil {
+		HandleResponseError(err, w, r)
+		return err
+	}
+
+	// the upgrade request is not traced by the router, its span is the one
+	// the spans of the messages of the connection are linked to
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "ws.upgrade", trace.WithAttributes(
+		attribute.String("request_id", utils.GetRequestID(ctx)),
+	))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	claims, err := h.authenticateWs(r)
 	if err != nil {
-		logrus.WithError(err).Error("Error upgrading to WebSocket")
-		http.Error(w, "Could not open WebSocket connection", http.StatusBadRequest)
+		HandleResponseError(err, w, r)
 		return err
 	}
 
-	user, err := h.userUsecase.GetUserFromToken(r.Context())
+	user, err := h.userUsecase.UserDetails(claims.Subject)
 	if err != nil {
 		logrus.WithError(err).Error("Error getting user from token")
-		http.Error(w, "Could not authenticate user", http.StatusUnauthorized)
+		err = httpError(http.StatusUnauthorized, ErrorCodeUserNotFound, "Could not authenticate user").WithInternalError(err)
+		HandleResponseError(err, w, r)
 		return err
 	}
 
-	client := &WsClient{ID: user.ID, Conn: conn, User: user}
-	h.localClients.Store(client.ID, client)
-	// h.registerClientInRedis(client.Id, h.serverID)
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied with an HTTP error
+		logrus.WithError(err).Error("Error upgrading to WebSocket")
+		return err
+	}
 
+	client := newWsClient(conn, user, wsCodecFor(conn.Subprotocol()), &h.globalConfig.API.WebSocket, h.metrics)
+	client.requestID = utils.GetRequestID(ctx)
+	client.upgradeSpan = span.SpanContext()
+	span.SetAttributes(attribute.String("ws.client_id", client.ID))
+	if claims.ExpiresAt != nil {
+		client.expireTokenAt(&claims.ExpiresAt.Time)
+	}
+
+	h.registerClient(r.Context(), client)
+
+	go client.writePump()
 	go h.HandleIncomingMessages(client)
 
 	return nil
 }
 
-func (h *WsHandler) handleCloseConnection(client *WsClient) {
-	client.Conn.Close()
-	h.localClients.Delete(client.ID)
+// registerClient makes a new client reachable from every instance. Once the
+// server is draining the client is sent away right after.
+func (h *WsHandler) registerClient(ctx context.Context, client *WsClient) {
+	// the user comes online when no instance knew of it yet
+	servers, err := h.broker.LookupClient(ctx, client.UserID)
+	if err != nil {
+		logrus.WithError(err).Error("Error looking up client in broker")
+	}
+	firstConnection := err == nil && len(servers) == 0
+
+	h.localClients.add(client)
+	h.metrics.connected(client.transportName())
+	if err := h.broker.RegisterClient(ctx, client.UserID, h.serverId); err != nil {
+		logrus.WithError(err).Error("Error registering client in broker")
+	}
 
-	// Unregister client from Redis
-	// h.unregisterClientFromRedis(client.ID)
+	// a connection accepted while Shutdown started draining may have been
+	// missed by it, and is sent away as well
+	if h.draining.Load() {
+		h.goAway(client)
+	}
+
+	// telling the peers takes a lookup per peer, which the connection
+	// does not need to wait for
+	go h.presenceConnected(client, firstConnection)
+}
+
+func (h *WsHandler) handleCloseConnection(client *WsClient) {
+	client.close(websocket.CloseNormalClosure, "")
+	client.expireTokenAt(nil)
+	h.localClients.remove(client)
+	h.metrics.disconnected(client.transportName(), client.closeReason())
+	h.stopTyping(client)
+	h.channels.unsubscribeAll(client)
+
+	if err := h.broker.UnregisterClient(context.Background(), client.UserID, h.serverId); err != nil {
+		logrus.WithError(err).Error("Error unregistering client from broker")
+	}
+	h.presenceDisconnected(client)
 }
 
 func (h *WsHandler) HandleIncomingMessages(client *WsClient) {
@@ -118,96 +290,224 @@ func (h *WsHandler) HandleIncomingMessages(client *WsClient) {
 		h.handleCloseConnection(client)
 	}()
 
+	if err := client.prepareRead(); err != nil {
+		logrus.WithError(err).Error("Error preparing WebSocket for reading")
+		return
+	}
+
 	for {
 		_, msg, err := client.Conn.ReadMessage()
 		if err != nil {
-			logrus.WithError(err).Error("Error reading message from WebSocket")
+			select {
+			case <-client.done:
+				// the server closed the connection, e.g. on drain, idle
+				// timeout or token expiry, which failed the read
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"client_id": client.ID,
+					"reason":    client.closeReason(),
+				}).Debug("WebSocket closed by server")
+				return
+			default:
+			}
+
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				logrus.WithField("client_id", client.ID).Info("WebSocket peer stopped answering pings")
+				client.close(websocket.CloseGoingAway, "pong timeout")
+
+			case errors.Is(err, websocket.ErrReadLimit):
+				// the connection already got a 1009 close frame
+				h.limiter.violated(client, "", wsLimitMessageSize)
+				client.close(websocket.CloseMessageTooBig, "message too big")
+
+			case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+				logrus.WithField("client_id", client.ID).Debug("WebSocket closed by peer")
+				client.close(websocket.CloseNormalClosure, "closed by peer")
+
+			default:
+				logrus.WithError(err).Error("Error reading message from WebSocket")
+				client.close(websocket.CloseAbnormalClosure, "read failed")
+			}
+			return
+		}
+		client.touch()
+
+		resp, closeConn := h.dispatch(client, msg)
+		client.sendResponse(resp)
+
+		if closeConn {
 			return
 		}
-		// TODO: You can parse the message and take appropriate actions based on the action type.
-		// For now, i just broadcast the message to all local clients.
-		go h.Broadcast2AllLocalClients(client.ID, msg)
 	}
 }
 
-func (h *WsHandler) Broadcast2AllLocalClients(clientId string, message []byte) {
-	h.localClients.Range(func(key, value interface{}) bool {
-		client, ok := value.(*WsClient)
-		if !ok || client == nil {
-			logrus.Error(("Invalid client in localClients map"))
-			return true // Continue to the next client
+// dispatch decodes a raw WsMessage with the codec of the client and routes it to the handler registered for
+// its action. It reports whether the connection should be closed once the
+// response has been sent.
+func (h *WsHandler) dispatch(client *WsClient, raw []byte) (*WsResponse, bool) {
+	msg := WsMessage{codec: client.codec}
+	if err := client.codec.DecodeMessage(raw, &msg); err != nil {
+		h.metrics.received("")
+		return WsErrorResponse("", WsErrorCodeBadJSON, "Could not decode message", err.Error()), false
+	}
+
+	if _, ok := h.actions[msg.Action]; ok {
+		h.metrics.received(msg.Action)
+	} else {
+		h.metrics.received(wsAnyAction)
+	}
+
+	if wsErr, disconnect := h.rateLimit(client, &msg); wsErr != nil {
+		resp := WsErrorResponse(msg.Action, wsErr.Code, wsErr.Message, wsErr.Details)
+		resp.ID = msg.ID
+
+		if disconnect {
+			logrus.WithField("client_id", client.ID).Warn("Disconnecting WebSocket client exceeding its rate limits")
+			client.sendResponse(resp)
+			client.close(websocket.ClosePolicyViolation, "rate limit exceeded")
 		}
+		return resp, disconnect
+	}
+
+	resp, closeConn := h.handleMessage(client, &msg)
+	resp.ID = msg.ID
+
+	return resp, closeConn
+}
+
+// handleMessage runs the handler of the action of msg within its own span.
+func (h *WsHandler) handleMessage(client *WsClient, msg *WsMessage) (*WsResponse, bool) {
+	log := logrus.WithFields(logrus.Fields{
+		"client_id":  client.ID,
+		"action":     msg.Action,
+		"message_id": msg.ID,
+		"request_id": client.requestID,
+	})
+
+	// every message gets a trace of its own, linked to the one of the
+	// request that opened the connection
+	_, span := tracer.Start(context.Background(), "ws."+string(msg.Action),
+		trace.WithLinks(trace.Link{SpanContext: client.upgradeSpan}),
+		trace.WithAttributes(
+			attribute.String("ws.client_id", client.ID),
+			attribute.String("ws.action", string(msg.Action)),
+			attribute.String("ws.message_id", msg.ID),
+			attribute.String("request_id", client.requestID),
+		))
+	defer span.End()
+
+	fn, ok := h.actions[msg.Action]
+	if !ok {
+		span.SetStatus(codes.Error, "unknown action")
+		return WsErrorResponse(msg.Action, WsErrorCodeUnknownAction, "Unknown action", string(msg.Action)), false
+	}
+
+	data, err := fn(client, msg)
 
+	var afterSend func()
+	if reply, ok := data.(*wsReply); ok {
+		data, afterSend = reply.data, reply.afterSend
+	}
+
+	switch {
+	case err == nil:
+		resp := WsSuccessResponse(msg.Action, data)
+		resp.afterSend = afterSend
+		return resp, false
+
+	case errors.Is(err, errWsCloseConnection):
+		resp := WsSuccessResponse(msg.Action, data)
+		resp.afterSend = afterSend
+		return resp, true
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	if errors.Is(err, errWsClientClosed) {
+		// nobody is left to read the reply
+		log.WithError(err).Debug("WebSocket action of closed client dropped")
+		return WsErrorResponse(msg.Action, WsErrorCodeUnexpectedFailure, "Connection is closed", ""), false
+	}
+
+	var wsErr *WsError
+	if errors.As(err, &wsErr) {
+		log.WithError(err).Debug("WebSocket action rejected")
+		return WsErrorResponse(msg.Action, wsErr.Code, wsErr.Message, wsErr.Details), false
+	}
+
+	log.WithError(err).Error("Unexpected failure handling WebSocket action")
+	return WsErrorResponse(msg.Action, WsErrorCodeUnexpectedFailure, "Unexpected failure, please check server logs for more information", ""), false
+}
+
+// sendResponse queues resp for the client, it is encoded with the codec of
+// the client when written.
+func (c *WsClient) sendResponse(resp *WsResponse) {
+	c.Send(newWsOutbound(resp))
+	resp.sent()
+}
+
+// Broadcast2AllLocalClients sends message, a WsResponse encoded as JSON, to
+// every connection open on this instance, except the one identified by
+// clientId.
+func (h *WsHandler) Broadcast2AllLocalClients(clientId string, message []byte) {
+	out := newWsOutboundJSON(message)
+	for _, client := range h.localClients.all() {
 		if client.ID != clientId { // Avoid sending to the sender
-			err := client.Conn.WriteMessage(websocket.TextMessage, message)
-			if err != nil {
-				logrus.WithError(err).Error("Error writing message to WebSocket")
-				return false // Stop iterating over clients
-			}
+			client.Send(out)
 		}
-		return true
-	})
+	}
+}
+
+// Broadcast2SpecificChannel sends message, a WsResponse encoded as JSON, to every client subscribed to
+// channelId, on this and every other instance, except the sender.
+func (h *WsHandler) Broadcast2SpecificChannel(channelId string, clientId string, message []byte) {
+	h.publish(channelId, brokerEnvelope{Origin: clientId, Payload: message})
+}
+
+// Send2User sends message, a WsResponse encoded as JSON, to every connection of userId, on whichever
+// instance it is connected to, except the one identified by clientId.
+func (h *WsHandler) Send2User(userId string, clientId string, message []byte) {
+	servers, err := h.broker.LookupClient(context.Background(), userId)
+	if err != nil {
+		logrus.WithError(err).WithField("user_id", userId).Error("Error looking up client in broker")
+		return
+	}
+
+	for _, serverId := range servers {
+		h.publish(serverTopic(serverId), brokerEnvelope{Origin: clientId, Target: userId, Payload: message})
+	}
+}
+
+// send2Users sends message, a WsResponse encoded as JSON, to every connection
+// of userIds as Send2User does, looking them up at once.
+func (h *WsHandler) send2Users(userIds []string, clientId string, message []byte) {
+	servers, err := h.broker.LookupClients(context.Background(), userIds)
+	if err != nil {
+		logrus.WithError(err).Error("Error looking up clients in broker")
+		return
+	}
 
-	// Publish message to Redis
-	// publishToRedis(clientId, message)
-}
-
-func (h *WsHandler) Broadcast2SpecificChannel(channelId, message []byte) {
-}
-
-// func (h *WsHandler) broadcastMessage2SpecificClients(clientIds []string, message []byte) {
-// 	for _, clientId := range clientIds {
-// 		client, ok := h.localClients.Load(clientId)
-// 		if !ok {
-// 			logrus.Errorf("Client not found in localClients map: %s", clientId)
-// 			continue
-// 		}
-
-// 		c, ok := client.(*WsClient)
-// 		if !ok || c == nil {
-// 			logrus.Error(("Invalid client in localClients map"))
-// 			continue
-// 		}
-
-// 		err := c.Conn.WriteMessage(websocket.TextMessage, message)
-// 		if err != nil {
-// 			logrus.WithError(err).Error("Error writing message to WebSocket")
-// 			continue
-// 		}
-// 	}
-// }
-
-// Register client in Redis
-// func (h *WsHandler) registerClientInRedis(clientID, serverID string) {
-// 	h.redisClient.HSet(ctx, "ws_clients", clientID, serverID)
-// }
-
-// Unregister client from Redis
-// func (h *WsHandler) unregisterClientFromRedis(clientID string) {
-// 	h.redisClient.HDel(ctx, "ws_clients", clientID)
-// }
-
-// Publish message to Redis
-// func (h *WsHandler) publishToRedis(senderID string, message []byte) {
-// 	err := redisClient.Publish(ctx, redisChannel, string(message)).Err()
-// 	if err != nil {
-// 		log.Println("Failed to publish message:", err)
-// 	}
-// }
-
-// // Subscribe to Redis channel
-// func (h *WsHandler) subscribeToRedis() {
-// 	pubsub := redisClient.Subscribe(ctx, redisChannel)
-// 	defer pubsub.Close()
-
-// 	for {
-// 		msg, err := pubsub.ReceiveMessage(ctx)
-// 		if err != nil {
-// 			log.Println("Failed to receive message:", err)
-// 			continue
-// 		}
-
-// 		// Broadcast received message locally
-// 		broadcastMessageLocally([]byte(msg.Payload))
-// 	}
-// }
+	for _, userId := range userIds {
+		for _, serverId := range servers[userId] {
+			h.publish(serverTopic(serverId), brokerEnvelope{Origin: clientId, Target: userId, Payload: message})
+		}
+	}
+}
+
+// broadcast2LocalSubscribers writes message to the clients of this instance
+// subscribed to channelId, except the sender and, when set, the connections
+// of exceptUserId.
+func (h *WsHandler) broadcast2LocalSubscribers(channelId string, clientId string, exceptUserId string, message *wsOutbound) {
+	for _, client := range h.channels.subscribers(channelId) {
+		if client.ID == clientId { // Avoid sending to the sender
+			continue
+		}
+		if exceptUserId != "" && client.UserID == exceptUserId {
+			continue
+		}
+
+		client.deliver(channelId, message)
+	}
+}
@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/app/domain/factory"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+var (
+	messageFactory = factory.MessageFactory{}
+)
+
+const (
+	// maxClientMessageIDLength is the size of the messages.client_message_id
+	// column.
+	maxClientMessageIDLength = 255
+	maxMessageAttachments    = 10
+)
+
+type SubscribeParams struct {
+	ConversationIDs []int64 `json:"conversation_ids"`
+}
+
+type SubscribeResult struct {
+	Channels []string `json:"channels"`
+}
+
+type SendMessageParams struct {
+	ConversationID  int64              `json:"conversation_id"`
+	ClientMessageID string             `json:"client_message_id"` // Generated by the client, retries must reuse it
+	Type            models.MessageType `json:"type"`
+	Message         string             `json:"message"`
+	Attachments     []AttachmentParams `json:"attachments,omitempty"`
+}
+
+type AttachmentParams struct {
+	Type models.AttachmentType `json:"type"`
+	URL  string                `json:"url"` // Where the client uploaded the file, http or https
+}
+
+// validate checks the parameters of a message to send, the conversation
+// excepted, and defaults its type.
+func (p *SendMessageParams) validate() error {
+	if p.ClientMessageID == "" {
+		return fmt.Errorf("client_message_id is required")
+	}
+
+	if len(p.ClientMessageID) > maxClientMessageIDLength {
+		return fmt.Errorf("client_message_id must not be longer than %d characters", maxClientMessageIDLength)
+	}
+
+	if strings.TrimSpace(p.Message) == "" && len(p.Attachments) == 0 {
+		return fmt.Errorf("message must not be empty")
+	}
+
+	switch p.Type {
+	case "":
+		p.Type = models.MessageTypeText
+	case models.MessageTypeText, models.MessageTypeImage, models.MessageTypeVideo, models.MessageTypeFile:
+	default:
+		return fmt.Errorf("Unsupported message type %q", p.Type)
+	}
+
+	if len(p.Attachments) > maxMessageAttachments {
+		return fmt.Errorf("A message must not have more than %d attachments", maxMessageAttachments)
+	}
+
+	for _, attachment := range p.Attachments {
+		switch attachment.Type {
+		case models.AttachmentTypeImage, models.AttachmentTypeVideo, models.AttachmentTypeFile:
+		default:
+			return fmt.Errorf("Unsupported attachment type %q", attachment.Type)
+		}
+
+		u, err := url.Parse(attachment.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid attachment url %q", attachment.URL)
+		}
+	}
+
+	return nil
+}
+
+type AckParams struct {
+	ConversationID int64 `json:"conversation_id"`
+	Seq            int64 `json:"seq"` // Sequence number of the latest message received
+}
+
+type AckResult struct {
+	ConversationID int64 `json:"conversation_id"`
+	DeliveredSeq   int64 `json:"delivered_seq"`
+}
+
+type UpdateProfileParams struct {
+	Name string `json:"name"`
+}
+
+// decodeParameters decodes the parameters of msg into v, with the codec msg was
+// decoded with.
+func decodeParameters(msg *WsMessage, v interface{}) error {
+	if len(msg.Parameters) == 0 {
+		return wsValidationError("Missing parameters for action %s", msg.Action)
+	}
+
+	if err := msg.codec.DecodeParameters(msg.Parameters, v); err != nil {
+		return wsValidationError("Invalid parameters for action %s", msg.Action).WithDetails(err.Error())
+	}
+
+	return nil
+}
+
+// requireParticipant makes sure the client's user takes part in the
+// conversation before it can read from or write to its channel.
+func (h *WsHandler) requireParticipant(client *WsClient, conversationId int64) error {
+	ok, err := h.chatUsecase.IsParticipant(conversationId, client.UserID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return wsError(WsErrorCodeForbidden, "Not a participant of conversation %d", conversationId)
+	}
+
+	return nil
+}
+
+func (h *WsHandler) handleSubscribe(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params SubscribeParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if len(params.ConversationIDs) == 0 {
+		return nil, wsValidationError("conversation_ids is required")
+	}
+
+	// check every conversation first so that a rejected request leaves
+	// the subscriptions of the client untouched
+	for _, conversationId := range params.ConversationIDs {
+		if err := h.requireParticipant(client, conversationId); err != nil {
+			return nil, err
+		}
+	}
+
+	result := SubscribeResult{Channels: make([]string, 0, len(params.ConversationIDs))}
+	for _, conversationId := range params.ConversationIDs {
+		channel := conversationChannel(conversationId)
+		if err := h.channels.subscribe(channel, client); err != nil {
+			return nil, err
+		}
+		result.Channels = append(result.Channels, channel)
+	}
+
+	return result, nil
+}
+
+func (h *WsHandler) handleUnsubscribe(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params SubscribeParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if len(params.ConversationIDs) == 0 {
+		return nil, wsValidationError("conversation_ids is required")
+	}
+
+	result := SubscribeResult{Channels: make([]string, 0, len(params.ConversationIDs))}
+	for _, conversationId := range params.ConversationIDs {
+		channel := conversationChannel(conversationId)
+		h.channels.unsubscribe(channel, client)
+		result.Channels = append(result.Channels, channel)
+	}
+
+	return result, nil
+}
+
+func (h *WsHandler) handleSendMessage(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params SendMessageParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if params.ConversationID <= 0 {
+		return nil, wsValidationError("conversation_id is required")
+	}
+
+	if err := params.validate(); err != nil {
+		return nil, wsValidationError("%s", err)
+	}
+
+	if err := h.requireParticipant(client, params.ConversationID); err != nil {
+		return nil, err
+	}
+
+	saved, _, err := h.sendMessage(client.UserID, client.ID, params)
+	return saved, err
+}
+
+// sendMessage stores the message the user sends and publishes it to the
+// subscribers of its conversation, the origin connection excepted. It reports
+// whether the message is a retry of one already sent. params must be valid
+// and the user a participant of the conversation.
+func (h *WsHandler) sendMessage(userId string, origin string, params SendMessageParams) (domain.Message, bool, error) {
+	message := messageFactory.CreateMessage(0, params.ConversationID, userId, params.Message)
+	message.ClientMessageID = params.ClientMessageID
+	message.Type = params.Type
+	for _, attachment := range params.Attachments {
+		message.Attachments = append(message.Attachments, domain.Attachment{
+			Type: attachment.Type,
+			URL:  attachment.URL,
+		})
+	}
+
+	saved, duplicate, err := h.chatUsecase.SendMessage(message)
+	if err != nil {
+		return domain.Message{}, false, err
+	}
+
+	// a retry is acknowledged again but the recipients already got the
+	// message the first time
+	if !duplicate {
+		event, err := json.Marshal(WsEventResponse(ActionSendMessage, saved))
+		if err != nil {
+			return domain.Message{}, false, err
+		}
+
+		h.publish(conversationChannel(params.ConversationID), brokerEnvelope{
+			Origin:    origin,
+			Seq:       saved.Seq,
+			StreamSeq: saved.StreamSeq,
+			Payload:   event,
+		})
+	}
+
+	return saved, duplicate, nil
+}
+
+// handleAck records that the client received the messages of a conversation
+// up to the given sequence number.
+func (h *WsHandler) handleAck(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params AckParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if params.ConversationID <= 0 {
+		return nil, wsValidationError("conversation_id is required")
+	}
+
+	if params.Seq <= 0 {
+		return nil, wsValidationError("seq must be positive")
+	}
+
+	receipt, senders, err := h.chatUsecase.AckDelivery(params.ConversationID, client.UserID, params.Seq)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, wsError(WsErrorCodeForbidden, "Not a participant of conversation %d", params.ConversationID)
+		}
+		return nil, err
+	}
+	h.pushReceipt(receipt, senders, client.ID)
+
+	return AckResult{ConversationID: params.ConversationID, DeliveredSeq: receipt.DeliveredSeq}, nil
+}
+
+func (h *WsHandler) handleUpdateProfile(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params UpdateProfileParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		return nil, wsValidationError("name must not be empty")
+	}
+
+	user := client.User()
+	user.Name = name
+
+	updated, err := h.userUsecase.PartialUpdateUser(user)
+	if err != nil {
+		return nil, err
+	}
+	client.setUser(updated)
+
+	return updated, nil
+}
+
+func (h *WsHandler) handleDisconnect(client *WsClient, msg *WsMessage) (interface{}, error) {
+	logrus.WithField("client_id", client.ID).Debug("Client requested to disconnect")
+	return nil, errWsCloseConnection
+}
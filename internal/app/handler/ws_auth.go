@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// wsSubprotocol is the subprotocol the server agrees on when the
+	// client does not negotiate a codec, it selects JSON. Browsers require
+	// a subprotocol to be echoed whenever the client offers some, which it
+	// has to when it passes the access token in them.
+	wsSubprotocol = "gomess"
+
+	// wsAccessTokenProtocolPrefix marks the Sec-WebSocket-Protocol entry
+	// that carries the access token, for clients that cannot set headers
+	// on the upgrade request, e.g. browsers.
+	wsAccessTokenProtocolPrefix = "access_token."
+)
+
+type ReauthenticateParams struct {
+	AccessToken string `json:"access_token"`
+}
+
+type ReauthenticateResult struct {
+	ExpiresAt *int64 `json:"expires_at,omitempty"` // Unix timestamp in seconds
+}
+
+// extractWsAccessToken returns the access token of a WebSocket upgrade request,
+// taken from the Authorization header, the access_token query parameter or the
+// Sec-WebSocket-Protocol header, in that order.
+func extractWsAccessToken(r *http.Request) (string, error) {
+	if r.Header.Get("Authorization") != "" {
+		return extractBearerToken(r)
+	}
+
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token, nil
+	}
+
+	for _, protocol := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(protocol, wsAccessTokenProtocolPrefix); ok && token != "" {
+			return token, nil
+		}
+	}
+
+	return "", httpError(http.StatusUnauthorized, ErrorCodeNoAuthorization, "An access token is required to open a WebSocket connection")
+}
+
+// authenticateWs verifies the access token of a WebSocket upgrade request and
+// returns its claims.
+func (h *WsHandler) authenticateWs(r *http.Request) (*AccessTokenClaims, error) {
+	tokenString, err := extractWsAccessToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := parseAccessToken(h.globalConfig, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return token.Claims.(*AccessTokenClaims), nil
+}
+
+// expireTokenAt closes the connection once its access token expires, unless
+// the client reauthenticates before. A nil expiry never closes it.
+func (c *WsClient) expireTokenAt(expiresAt *time.Time) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.tokenTimer != nil {
+		c.tokenTimer.Stop()
+		c.tokenTimer = nil
+	}
+
+	if expiresAt == nil {
+		return
+	}
+
+	c.tokenTimer = time.AfterFunc(time.Until(*expiresAt), func() {
+		logrus.WithField("client_id", c.ID).Info("Closing WebSocket connection with expired access token")
+
+		resp := WsErrorResponse(ActionReauthenticate, WsErrorCodeTokenExpired, "Access token expired", "")
+		resp.EventID = uuid.Must(uuid.NewV4()).String()
+		c.sendResponse(resp)
+		c.close(websocket.ClosePolicyViolation, "access token expired")
+	})
+}
+
+func (h *WsHandler) handleReauthenticate(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params ReauthenticateParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if params.AccessToken == "" {
+		return nil, wsValidationError("access_token is required")
+	}
+
+	token, err := parseAccessToken(h.globalConfig, params.AccessToken)
+	if err != nil {
+		return nil, wsError(WsErrorCodeBadJWT, "Invalid access token").WithDetails(err.Error())
+	}
+
+	claims := token.Claims.(*AccessTokenClaims)
+	if claims.Subject != client.UserID {
+		return nil, wsError(WsErrorCodeForbidden, "Access token belongs to another user")
+	}
+
+	var result ReauthenticateResult
+	var expiresAt *time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = &claims.ExpiresAt.Time
+		exp := claims.ExpiresAt.Unix()
+		result.ExpiresAt = &exp
+	}
+	client.expireTokenAt(expiresAt)
+
+	return result, nil
+}
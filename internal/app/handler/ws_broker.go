@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// brokerEnvelope wraps the messages exchanged between gomess instances.
+type brokerEnvelope struct {
+	Origin     string          `json:"origin,omitempty"`      // Connection that sent the message, it is not delivered back to it
+	ExceptUser string          `json:"except_user,omitempty"` // User none of whose connections the message is delivered to
+	Target     string          `json:"target,omitempty"`      // User the message is addressed to, for server topics
+	Channel    string          `json:"channel,omitempty"`     // Channel whose local subscribers the message is addressed to, for server topics
+	ReplyTo    string          `json:"reply_to,omitempty"`    // Topic to send the number of connections the message was delivered to
+	Seq        int64           `json:"seq,omitempty"`         // Sequence number of the message carried by a conversation event
+	StreamSeq  int64           `json:"stream_seq,omitempty"`  // Stream sequence number of that message
+	SentAt     int64           `json:"sent_at,omitempty"`     // Unix nanoseconds the envelope was published at
+	Payload    json.RawMessage `json:"payload"`               // WsResponse written to the WebSocket connections, encoded as JSON
+
+	// Unsubscribe lists the users whose connections leave the channel once
+	// the message is delivered, because they left the conversation.
+	Unsubscribe []string `json:"unsubscribe,omitempty"`
+}
+
+// serverTopic returns the broker topic of the events addressed to the clients
+// connected to serverId.
+func serverTopic(serverId string) string {
+	return "server:" + serverId
+}
+
+func (h *WsHandler) publish(topic string, envelope brokerEnvelope) {
+	envelope.SentAt = time.Now().UnixNano()
+
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		logrus.WithError(err).WithField("topic", topic).Error("Error encoding broker envelope")
+		return
+	}
+
+	if err := h.broker.Publish(context.Background(), topic, b); err != nil {
+		logrus.WithError(err).WithField("topic", topic).Error("Error publishing to broker")
+	}
+}
+
+// subscribeToChannel starts receiving the events of a channel from the broker
+// once a local client subscribes to it.
+func (h *WsHandler) subscribeToChannel(channel string) error {
+	return h.broker.Subscribe(context.Background(), channel, func(payload []byte) {
+		var envelope brokerEnvelope
+		if err := json.Unmarshal(payload, &envelope); err != nil {
+			logrus.WithError(err).WithField("channel", channel).Error("Error decoding broker envelope")
+			return
+		}
+
+		h.metrics.fannedOut(envelope.SentAt)
+
+		out := newWsOutboundJSON(envelope.Payload)
+		out.seq = envelope.Seq
+		out.cursor = envelope.StreamSeq
+		h.broadcast2LocalSubscribers(channel, envelope.Origin, envelope.ExceptUser, out)
+
+		for _, userId := range envelope.Unsubscribe {
+			for _, client := range h.localClients.userClients(userId) {
+				h.channels.unsubscribe(channel, client)
+			}
+		}
+	})
+}
+
+// unsubscribeFromChannel stops receiving the events of a channel once no local
+// client is subscribed to it anymore.
+func (h *WsHandler) unsubscribeFromChannel(channel string) {
+	if err := h.broker.Unsubscribe(context.Background(), channel); err != nil {
+		logrus.WithError(err).WithField("channel", channel).Error("Error unsubscribing from broker")
+	}
+}
+
+// handleServerEvent delivers the events addressed to a user connected to this
+// instance, or to the local subscribers of a channel.
+func (h *WsHandler) handleServerEvent(payload []byte) {
+	var envelope brokerEnvelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		logrus.WithError(err).Error("Error decoding broker envelope")
+		return
+	}
+	h.metrics.fannedOut(envelope.SentAt)
+
+	out := newWsOutboundJSON(envelope.Payload)
+	delivered := make(map[string]int)
+
+	if envelope.Channel != "" {
+		for _, client := range h.channels.subscribers(envelope.Channel) {
+			if client.ID != envelope.Origin && client.UserID != envelope.ExceptUser { // Avoid sending to the sender
+				client.deliver(envelope.Channel, out)
+				delivered[client.UserID]++
+			}
+		}
+	} else {
+		for _, client := range h.localClients.userClients(envelope.Target) {
+			if client.ID != envelope.Origin { // Avoid sending to the sender
+				client.Send(out)
+				delivered[client.UserID]++
+			}
+		}
+	}
+
+	h.replyDelivered(envelope, delivered)
+}
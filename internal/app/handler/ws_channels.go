@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errWsClientClosed is returned when subscribing a client that is closed.
+var errWsClientClosed = errors.New("websocket client is closed")
+
+// conversationChannel returns the name of the channel that carries the
+// realtime events of a conversation.
+func conversationChannel(conversationId int64) string {
+	return fmt.Sprintf("conversation:%d", conversationId)
+}
+
+// channelRegistry keeps track of which clients are subscribed to which
+// channels. It is safe for concurrent use.
+type channelRegistry struct {
+	sync.RWMutex
+
+	channels map[string]map[*WsClient]struct{}
+	clients  map[*WsClient]map[string]struct{}
+
+	// activate is called when a channel gets its first local subscriber,
+	// deactivate when it loses its last one. They may reach the broker over
+	// the network so they are called with the registry unlocked, which
+	// leaves broadcasts unaffected, and serialized by syncMu. active are the
+	// channels activate was last called for.
+	activate   func(channel string) error
+	deactivate func(channel string)
+	syncMu     sync.Mutex
+	active     map[string]struct{}
+}
+
+func newChannelRegistry(activate func(channel string) error, deactivate func(channel string)) *channelRegistry {
+	return &channelRegistry{
+		channels:   make(map[string]map[*WsClient]struct{}),
+		clients:    make(map[*WsClient]map[string]struct{}),
+		activate:   activate,
+		deactivate: deactivate,
+		active:     make(map[string]struct{}),
+	}
+}
+
+// subscribe adds client to the subscribers of channel. A closed client is
+// rejected, as it may already have been unsubscribed from every channel.
+func (r *channelRegistry) subscribe(channel string, client *WsClient) error {
+	r.Lock()
+	select {
+	case <-client.done:
+		r.Unlock()
+		return errWsClientClosed
+	default:
+	}
+
+	subscribers, ok := r.channels[channel]
+	if !ok {
+		subscribers = make(map[*WsClient]struct{})
+		r.channels[channel] = subscribers
+	}
+	subscribers[client] = struct{}{}
+
+	channels, ok := r.clients[client]
+	if !ok {
+		channels = make(map[string]struct{})
+		r.clients[client] = channels
+	}
+	channels[channel] = struct{}{}
+	r.Unlock()
+
+	if err := r.sync(channel); err != nil {
+		r.unsubscribe(channel, client)
+		return err
+	}
+
+	return nil
+}
+
+// unsubscribe removes client from the subscribers of channel.
+func (r *channelRegistry) unsubscribe(channel string, client *WsClient) {
+	r.Lock()
+	r.unsubscribeLocked(channel, client)
+	r.Unlock()
+
+	r.sync(channel)
+}
+
+// unsubscribeAll removes client from every channel it is subscribed to.
+func (r *channelRegistry) unsubscribeAll(client *WsClient) {
+	r.Lock()
+	channels := make([]string, 0, len(r.clients[client]))
+	for channel := range r.clients[client] {
+		channels = append(channels, channel)
+	}
+	for _, channel := range channels {
+		r.unsubscribeLocked(channel, client)
+	}
+	r.Unlock()
+
+	for _, channel := range channels {
+		r.sync(channel)
+	}
+}
+
+func (r *channelRegistry) unsubscribeLocked(channel string, client *WsClient) {
+	if subscribers, ok := r.channels[channel]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(r.channels, channel)
+		}
+	}
+
+	if channels, ok := r.clients[client]; ok {
+		delete(channels, channel)
+		if len(channels) == 0 {
+			delete(r.clients, client)
+		}
+	}
+}
+
+// sync activates channel when it has local subscribers and deactivates it
+// when it has none, unless it already is. Only a failed activation is
+// reported.
+func (r *channelRegistry) sync(channel string) error {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	r.RLock()
+	_, subscribed := r.channels[channel]
+	r.RUnlock()
+
+	_, active := r.active[channel]
+	switch {
+	case subscribed && !active:
+		if err := r.activate(channel); err != nil {
+			return err
+		}
+		r.active[channel] = struct{}{}
+
+	case !subscribed && active:
+		r.deactivate(channel)
+		delete(r.active, channel)
+	}
+
+	return nil
+}
+
+// subscribers returns a snapshot of the clients subscribed to channel.
+func (r *channelRegistry) subscribers(channel string) []*WsClient {
+	r.RLock()
+	defer r.RUnlock()
+
+	subscribers := make([]*WsClient, 0, len(r.channels[channel]))
+	for client := range r.channels[channel] {
+		subscribers = append(subscribers, client)
+	}
+
+	return subscribers
+}
@@ -0,0 +1,398 @@
+package handler
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/config"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+type WsClient struct {
+	ID     string          // Connection ID, distinct for every device of a user
+	UserID string          // User the connection is authenticated as, it never changes
+	Conn   *websocket.Conn // Read from for WebSocket clients, nil for event streams
+
+	// user is the profile of the user, which update_profile changes while
+	// other goroutines read the client.
+	userMu sync.RWMutex
+	user   domain.User
+
+	// codec is the wire format negotiated for the connection, transport
+	// what the encoded messages are written to.
+	codec     wsCodec
+	transport wsTransport
+	config    *config.WebSocketConfiguration
+	metrics   *wsMetrics
+
+	// requestID and upgradeSpan identify the request that opened the
+	// connection, which the spans of its messages are linked to.
+	requestID   string
+	upgradeSpan trace.SpanContext
+
+	// send queues the outbound messages, written by writePump only, so
+	// that there is a single writer per connection as gorilla/websocket
+	// requires.
+	send   chan *wsOutbound
+	sendMu sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+
+	// goingAway makes sure the client is told only once that the server is
+	// going away.
+	goingAway sync.Once
+
+	// lastActivity is the time of the last message received from the
+	// client, as Unix nanoseconds.
+	lastActivity atomic.Int64
+
+	tokenMu    sync.Mutex
+	tokenTimer *time.Timer
+
+	// held keeps the live events of the channels being replayed until the
+	// replay is over.
+	heldMu sync.Mutex
+	held   map[string][]*wsOutbound
+
+	// limiters are the token buckets of the actions of the client,
+	// violations counts how often it exceeded them.
+	limitersMu sync.Mutex
+	limiters   map[WsAction]*rate.Limiter
+	violations *rate.Limiter
+}
+
+func newWsClient(conn *websocket.Conn, user domain.User, codec wsCodec, wsConfig *config.WebSocketConfiguration, metrics *wsMetrics) *WsClient {
+	c := newClient(&wsConnTransport{conn: conn, codec: codec, config: wsConfig}, user, codec, wsConfig, metrics)
+	c.Conn = conn
+
+	return c
+}
+
+func newClient(transport wsTransport, user domain.User, codec wsCodec, wsConfig *config.WebSocketConfiguration, metrics *wsMetrics) *WsClient {
+	c := &WsClient{
+		ID:        uuid.Must(uuid.NewV4()).String(),
+		UserID:    user.ID,
+		user:      user,
+		codec:     codec,
+		transport: transport,
+		config:    wsConfig,
+		metrics:   metrics,
+		send:      make(chan *wsOutbound, wsConfig.SendBufferSize),
+		done:      make(chan struct{}),
+	}
+	c.touch()
+
+	return c
+}
+
+// User returns the profile of the user of the client.
+func (c *WsClient) User() domain.User {
+	c.userMu.RLock()
+	defer c.userMu.RUnlock()
+
+	return c.user
+}
+
+// setUser replaces the profile of the user of the client.
+func (c *WsClient) setUser(user domain.User) {
+	c.userMu.Lock()
+	defer c.userMu.Unlock()
+
+	c.user = user
+}
+
+// prepareRead arms the read deadline of the connection, which every pong
+// extends, so that reads fail once the peer stops answering pings.
+func (c *WsClient) prepareRead() error {
+	c.Conn.SetReadLimit(c.config.MaxMessageSize)
+	c.Conn.SetPongHandler(func(string) error {
+		return c.extendReadDeadline()
+	})
+
+	return c.extendReadDeadline()
+}
+
+func (c *WsClient) extendReadDeadline() error {
+	return c.Conn.SetReadDeadline(time.Now().Add(c.config.PingInterval + c.config.PongTimeout))
+}
+
+// transportName names the transport of the client in metrics.
+func (c *WsClient) transportName() string {
+	if c.Conn == nil {
+		return "sse"
+	}
+	return "websocket"
+}
+
+// closeReason describes why the client was closed, once it is.
+func (c *WsClient) closeReason() string {
+	switch {
+	case c.closeText != "":
+		return c.closeText
+	case c.closeCode == websocket.CloseNormalClosure:
+		return "normal"
+	case c.closeCode == websocket.CloseAbnormalClosure:
+		return "abnormal"
+	default:
+		return strconv.Itoa(c.closeCode)
+	}
+}
+
+// touch records that the client has just sent a message.
+func (c *WsClient) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long ago the client sent its last message.
+func (c *WsClient) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastActivity.Load()))
+}
+
+// Send queues message to be written to the client. It never blocks: when the
+// queue is full the configured overflow policy applies.
+func (c *WsClient) Send(message *wsOutbound) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		c.metrics.queued(len(c.send))
+		return
+	default:
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"client_id":       c.ID,
+		"overflow_policy": c.config.OverflowPolicy,
+	})
+
+	switch c.config.OverflowPolicy {
+	case config.WsOverflowDisconnect:
+		log.Warn("Outbound queue of WebSocket client is full, disconnecting slow consumer")
+		c.metrics.drop("slow_consumer")
+		c.close(websocket.ClosePolicyViolation, "slow consumer")
+
+	default:
+		log.Warn("Outbound queue of WebSocket client is full, dropping oldest message")
+
+		select {
+		case <-c.send:
+			c.metrics.drop("overflow")
+		default:
+		}
+
+		select {
+		case c.send <- message:
+		default:
+		}
+	}
+}
+
+// close stops the client. The messages queued so far are flushed and a close
+// frame with the given code is sent before the connection is closed. It is
+// safe to call close more than once, only the first call has an effect.
+func (c *WsClient) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// writePump writes the queued messages to the connection until the client is
+// closed or a write fails. It also pings the client and reaps it once it has
+// been idle for too long.
+func (c *WsClient) writePump() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer ticker.Stop()
+	defer c.transport.Close()
+
+	for {
+		select {
+		case message := <-c.send:
+			if err := c.writeOutbound(message); err != nil {
+				logrus.WithError(err).WithField("client_id", c.ID).Error("Error writing message to WebSocket")
+				c.close(websocket.CloseAbnormalClosure, "write failed")
+				return
+			}
+
+		case <-ticker.C:
+			if c.config.MaxIdle > 0 && c.idleFor() > c.config.MaxIdle {
+				logrus.WithField("client_id", c.ID).Info("Closing idle WebSocket connection")
+				c.close(websocket.CloseGoingAway, "idle timeout")
+				continue
+			}
+
+			if err := c.transport.WritePing(time.Now().Add(c.config.WriteTimeout)); err != nil {
+				logrus.WithError(err).WithField("client_id", c.ID).Info("Error writing ping to WebSocket")
+				c.close(websocket.CloseAbnormalClosure, "write failed")
+				return
+			}
+
+		case <-c.done:
+			c.flush()
+			return
+		}
+	}
+}
+
+// flush writes what is left in the queue followed by the close frame. The
+// whole flush shares a single write deadline so a stalled connection cannot
+// hold it up.
+func (c *WsClient) flush() {
+	deadline := time.Now().Add(c.config.WriteTimeout)
+
+	for {
+		select {
+		case message := <-c.send:
+			b, err := message.encode(c.codec)
+			if err != nil {
+				c.metrics.drop("encode_error")
+				continue
+			}
+
+			if err := c.transport.WriteMessage(message, b, deadline); err != nil {
+				return
+			}
+			c.metrics.written(message.action)
+
+		default:
+			// 1006 is reserved for connections that were closed without
+			// a close frame and must not be sent
+			if c.closeCode == websocket.CloseAbnormalClosure {
+				return
+			}
+
+			if err := c.transport.WriteClose(c.closeCode, c.closeText, deadline); err != nil {
+				logrus.WithError(err).WithField("client_id", c.ID).Debug("Error writing close message to WebSocket")
+			}
+			return
+		}
+	}
+}
+
+// writeOutbound encodes message with the codec of the client and writes it.
+// A message that cannot be encoded is dropped.
+func (c *WsClient) writeOutbound(message *wsOutbound) error {
+	b, err := message.encode(c.codec)
+	if err != nil {
+		logrus.WithError(err).WithField("client_id", c.ID).Error("Error encoding WebSocket message")
+		c.metrics.drop("encode_error")
+		return nil
+	}
+
+	if err := c.transport.WriteMessage(message, b, time.Now().Add(c.config.WriteTimeout)); err != nil {
+		return err
+	}
+	c.metrics.written(message.action)
+
+	return nil
+}
+
+// localClientRegistry keeps the connections open on this instance, grouped by
+// user so that a user can be connected from several devices at once. It is
+// safe for concurrent use.
+type localClientRegistry struct {
+	sync.RWMutex
+
+	users   map[string]map[string]*WsClient
+	clients map[string]*WsClient
+}
+
+func newLocalClientRegistry() *localClientRegistry {
+	return &localClientRegistry{
+		users:   make(map[string]map[string]*WsClient),
+		clients: make(map[string]*WsClient),
+	}
+}
+
+func (r *localClientRegistry) add(client *WsClient) {
+	r.Lock()
+	defer r.Unlock()
+
+	clients, ok := r.users[client.UserID]
+	if !ok {
+		clients = make(map[string]*WsClient)
+		r.users[client.UserID] = clients
+	}
+	clients[client.ID] = client
+	r.clients[client.ID] = client
+}
+
+func (r *localClientRegistry) remove(client *WsClient) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.clients, client.ID)
+
+	clients, ok := r.users[client.UserID]
+	if !ok {
+		return
+	}
+
+	delete(clients, client.ID)
+	if len(clients) == 0 {
+		delete(r.users, client.UserID)
+	}
+}
+
+// client returns the connection identified by clientId, nil when it is not
+// open on this instance.
+func (r *localClientRegistry) client(clientId string) *WsClient {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.clients[clientId]
+}
+
+// count returns the number of connections.
+func (r *localClientRegistry) count() int {
+	r.RLock()
+	defer r.RUnlock()
+
+	return len(r.clients)
+}
+
+// userClients returns a snapshot of the connections of userId.
+func (r *localClientRegistry) userClients(userId string) []*WsClient {
+	r.RLock()
+	defer r.RUnlock()
+
+	clients := make([]*WsClient, 0, len(r.users[userId]))
+	for _, client := range r.users[userId] {
+		clients = append(clients, client)
+	}
+
+	return clients
+}
+
+// all returns a snapshot of every connection.
+func (r *localClientRegistry) all() []*WsClient {
+	r.RLock()
+	defer r.RUnlock()
+
+	var clients []*WsClient
+	for _, userClients := range r.users {
+		for _, client := range userClients {
+			clients = append(clients, client)
+		}
+	}
+
+	return clients
+}
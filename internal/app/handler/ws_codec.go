@@ -0,0 +1,443 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Subprotocols negotiating the wire format of a connection. The server picks
+// the first one of wsCodecs offered by the client, the bare wsSubprotocol and
+// connections offering none of them use JSON.
+const (
+	wsSubprotocolJSON     = "gomess.json"
+	wsSubprotocolMsgpack  = "gomess.msgpack"
+	wsSubprotocolProtobuf = "gomess.protobuf"
+)
+
+// wsCodec encodes and decodes the messages exchanged over a connection.
+type wsCodec interface {
+	// Subprotocol is the Sec-WebSocket-Protocol value selecting the codec.
+	Subprotocol() string
+	// FrameType is the WebSocket message type the codec writes.
+	FrameType() int
+
+	DecodeMessage(data []byte, msg *WsMessage) error
+	DecodeParameters(params WsParameters, v interface{}) error
+	EncodeResponse(resp *WsResponse) ([]byte, error)
+}
+
+var (
+	jsonCodec     wsCodec = wsJSONCodec{}
+	msgpackCodec  wsCodec = wsMsgpackCodec{}
+	protobufCodec wsCodec = wsProtobufCodec{}
+
+	// wsCodecs lists the codecs in order of preference of the server.
+	wsCodecs = []wsCodec{protobufCodec, msgpackCodec, jsonCodec}
+)
+
+// wsSubprotocols returns the subprotocols the upgrader accepts.
+func wsSubprotocols() []string {
+	protocols := make([]string, 0, len(wsCodecs)+1)
+	for _, codec := range wsCodecs {
+		protocols = append(protocols, codec.Subprotocol())
+	}
+	return append(protocols, wsSubprotocol)
+}
+
+// wsCodecFor returns the codec selected by the negotiated subprotocol.
+func wsCodecFor(subprotocol string) wsCodec {
+	for _, codec := range wsCodecs {
+		if codec.Subprotocol() == subprotocol {
+			return codec
+		}
+	}
+	return jsonCodec
+}
+
+// WsParameters are the parameters of a WsMessage, still encoded in the format
+// of the connection until the action handler decodes them.
+type WsParameters []byte
+
+func (p *WsParameters) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*p = nil
+		return nil
+	}
+	*p = append((*p)[:0], b...)
+	return nil
+}
+
+func (p *WsParameters) DecodeMsgpack(dec *msgpack.Decoder) error {
+	raw, err := dec.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(raw, []byte{msgpackNil}) {
+		*p = nil
+		return nil
+	}
+	*p = WsParameters(raw)
+	return nil
+}
+
+type wsJSONCodec struct{}
+
+func (wsJSONCodec) Subprotocol() string { return wsSubprotocolJSON }
+func (wsJSONCodec) FrameType() int      { return websocket.TextMessage }
+
+func (wsJSONCodec) DecodeMessage(data []byte, msg *WsMessage) error {
+	return json.Unmarshal(data, msg)
+}
+
+func (wsJSONCodec) DecodeParameters(params WsParameters, v interface{}) error {
+	return json.Unmarshal(params, v)
+}
+
+func (wsJSONCodec) EncodeResponse(resp *WsResponse) ([]byte, error) {
+	return json.Marshal(resp)
+}
+
+// msgpackNil is the MessagePack encoding of nil.
+const msgpackNil = 0xc0
+
+// wsMsgpackCodec encodes messages as MessagePack maps keyed like their JSON
+// counterparts. Parameters and data hold the same tree as their JSON
+// encoding, times included, which are RFC 3339 strings rather than MessagePack
+// timestamps.
+type wsMsgpackCodec struct{}
+
+func (wsMsgpackCodec) Subprotocol() string { return wsSubprotocolMsgpack }
+func (wsMsgpackCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (wsMsgpackCodec) DecodeMessage(data []byte, msg *WsMessage) error {
+	return decodeMsgpack(data, msg)
+}
+
+func (wsMsgpackCodec) DecodeParameters(params WsParameters, v interface{}) error {
+	var tree interface{}
+	if err := decodeMsgpack(params, &tree); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (wsMsgpackCodec) EncodeResponse(resp *WsResponse) ([]byte, error) {
+	data, err := toJSONTree(resp.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	// the response is shared by the clients it is sent to, the data is
+	// replaced on a copy
+	tree := *resp
+	tree.Data = fromJSONNumbers(data)
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(&tree); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeMsgpack(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// wsProtobufCodec encodes messages as described in ws.proto. Parameters and
+// data are carried as google.protobuf.Value, which holds the same tree as
+// their JSON encoding, except for the integers a double cannot hold exactly,
+// which are carried as strings.
+type wsProtobufCodec struct{}
+
+func (wsProtobufCodec) Subprotocol() string { return wsSubprotocolProtobuf }
+func (wsProtobufCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (wsProtobufCodec) DecodeMessage(data []byte, msg *WsMessage) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			msg.Version, n = protowire.ConsumeString(data)
+		case num == 2 && typ == protowire.BytesType:
+			var action string
+			action, n = protowire.ConsumeString(data)
+			msg.Action = WsAction(action)
+		case num == 3 && typ == protowire.VarintType:
+			var timestamp uint64
+			timestamp, n = protowire.ConsumeVarint(data)
+			msg.Timestamp = int64(timestamp)
+		case num == 4 && typ == protowire.BytesType:
+			var params []byte
+			params, n = protowire.ConsumeBytes(data)
+			msg.Parameters = append(WsParameters(nil), params...)
+		case num == 5 && typ == protowire.BytesType:
+			msg.ID, n = protowire.ConsumeString(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+func (wsProtobufCodec) DecodeParameters(params WsParameters, v interface{}) error {
+	var value structpb.Value
+	if err := proto.Unmarshal(params, &value); err != nil {
+		return err
+	}
+
+	b, err := value.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (wsProtobufCodec) EncodeResponse(resp *WsResponse) ([]byte, error) {
+	var b []byte
+	b = appendProtoString(b, 1, resp.Version)
+	b = appendProtoString(b, 2, resp.Status)
+	b = appendProtoString(b, 3, string(resp.Action))
+	if resp.Timestamp != 0 {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(resp.Timestamp))
+	}
+
+	if resp.Data != nil {
+		data, err := toProtoValue(resp.Data)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, data)
+	}
+
+	b = appendProtoString(b, 7, resp.ID)
+	b = appendProtoString(b, 8, resp.EventID)
+
+	if resp.Error != nil {
+		var e []byte
+		if resp.Error.Code != 0 {
+			e = protowire.AppendTag(e, 1, protowire.VarintType)
+			e = protowire.AppendVarint(e, uint64(int64(resp.Error.Code)))
+		}
+		e = appendProtoString(e, 2, resp.Error.Message)
+		e = appendProtoString(e, 3, resp.Error.Details)
+
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, e)
+	}
+
+	return b, nil
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// maxExactProtoInt is the largest integer a double, the number type of
+// google.protobuf.Value, holds exactly.
+const maxExactProtoInt = 1 << 53
+
+// toProtoValue encodes v as a google.protobuf.Value, going through its JSON
+// encoding so that the field names and formats match the JSON codec.
+func toProtoValue(v interface{}) ([]byte, error) {
+	tree, err := toJSONTree(v)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := protoValueOf(tree)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(value)
+}
+
+// protoValueOf converts tree, decoded from JSON by toJSONTree, into a
+// google.protobuf.Value. Integers beyond maxExactProtoInt become strings so
+// that large IDs keep their precision.
+func protoValueOf(tree interface{}) (*structpb.Value, error) {
+	switch v := tree.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			if i > maxExactProtoInt || i < -maxExactProtoInt {
+				return structpb.NewStringValue(v.String()), nil
+			}
+			return structpb.NewNumberValue(float64(i)), nil
+		}
+		f, err := v.Float64()
+		if err != nil || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("invalid number %s", v)
+		}
+		return structpb.NewNumberValue(f), nil
+
+	case map[string]interface{}:
+		fields := make(map[string]*structpb.Value, len(v))
+		for key, value := range v {
+			field, err := protoValueOf(value)
+			if err != nil {
+				return nil, err
+			}
+			fields[key] = field
+		}
+		return structpb.NewStructValue(&structpb.Struct{Fields: fields}), nil
+
+	case []interface{}:
+		values := make([]*structpb.Value, len(v))
+		for i, value := range v {
+			item, err := protoValueOf(value)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = item
+		}
+		return structpb.NewListValue(&structpb.ListValue{Values: values}), nil
+
+	default:
+		// strings, booleans and null
+		return structpb.NewValue(v)
+	}
+}
+
+// toJSONTree returns the tree of maps, slices and scalars v is encoded as in
+// JSON, numbers left as json.Number so that integers keep their precision.
+func toJSONTree(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// wsOutbound is a message queued for one or more clients. It is encoded at
+// most once per codec however many clients it is sent to.
+type wsOutbound struct {
+	mu      sync.Mutex
+	resp    *WsResponse
+	encoded map[string][]byte
+	action  WsAction
+
+	// seq is the sequence number of the message a conversation event
+	// carries, so that it is not delivered again after a replay, cursor
+	// its stream sequence number, which event streams resume from.
+	seq    int64
+	cursor int64
+}
+
+func newWsOutbound(resp *WsResponse) *wsOutbound {
+	return &wsOutbound{resp: resp, encoded: make(map[string][]byte), action: resp.Action}
+}
+
+// newWsOutboundJSON wraps a WsResponse already encoded as JSON, e.g. one
+// received from the broker. It is only decoded in full for clients of other
+// codecs.
+func newWsOutboundJSON(b []byte) *wsOutbound {
+	var head struct {
+		Action WsAction `json:"action"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		logrus.WithError(err).Debug("Error decoding the action of a websocket response")
+	}
+
+	return &wsOutbound{encoded: map[string][]byte{wsSubprotocolJSON: b}, action: head.Action}
+}
+
+// encode returns the message encoded with codec.
+func (o *wsOutbound) encode(codec wsCodec) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if b, ok := o.encoded[codec.Subprotocol()]; ok {
+		return b, nil
+	}
+
+	if o.resp == nil {
+		// numbers are kept as written so that integers reach the other
+		// codecs as integers, and large IDs keep their precision
+		var resp WsResponse
+		dec := json.NewDecoder(bytes.NewReader(o.encoded[wsSubprotocolJSON]))
+		dec.UseNumber()
+		if err := dec.Decode(&resp); err != nil {
+			return nil, fmt.Errorf("decoding websocket response: %w", err)
+		}
+		resp.Data = fromJSONNumbers(resp.Data)
+		o.resp = &resp
+	}
+
+	b, err := codec.EncodeResponse(o.resp)
+	if err != nil {
+		return nil, err
+	}
+	o.encoded[codec.Subprotocol()] = b
+
+	return b, nil
+}
+
+// fromJSONNumbers replaces the json.Number values of v, decoded from JSON into
+// an interface{}, with an int64 when they are integers and a float64
+// otherwise.
+func fromJSONNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = fromJSONNumbers(value)
+		}
+		return v
+
+	case []interface{}:
+		for i, value := range v {
+			v[i] = fromJSONNumbers(value)
+		}
+		return v
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,293 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type testCodecData struct {
+	ID        int64     `json:"id"`
+	Seq       int64     `json:"seq"`
+	Ratio     float64   `json:"ratio"`
+	Text      string    `json:"text"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"created_at"`
+	Deleted   *string   `json:"deleted"`
+}
+
+// testCodecBigID does not fit in a double.
+const testCodecBigID = int64(1)<<60 + 1
+
+func newTestCodecData() testCodecData {
+	return testCodecData{
+		ID:        testCodecBigID,
+		Seq:       42,
+		Ratio:     0.5,
+		Text:      "hello",
+		Tags:      []string{"a", "b"},
+		CreatedAt: time.Date(2026, 10, 18, 12, 30, 45, 123456789, time.UTC),
+	}
+}
+
+func newTestCodecResponse() *WsResponse {
+	resp := WsEventResponse(ActionSendMessage, newTestCodecData())
+	resp.ID = "reply-1"
+	return resp
+}
+
+// decodeTestResponse decodes a response written by codec into the fields
+// common to every codec, the data as a tree of maps, slices and scalars with
+// int64 integers.
+func decodeTestResponse(t *testing.T, codec wsCodec, b []byte) (WsResponse, interface{}) {
+	t.Helper()
+
+	var resp WsResponse
+	switch codec {
+	case jsonCodec:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("decoding JSON response: %v", err)
+		}
+		resp.Data = fromJSONNumbers(resp.Data)
+
+	case msgpackCodec:
+		if err := decodeMsgpack(b, &resp); err != nil {
+			t.Fatalf("decoding MessagePack response: %v", err)
+		}
+		resp.Data = normalizeMsgpackTree(resp.Data)
+
+	case protobufCodec:
+		resp = decodeTestProtoResponse(t, b)
+	}
+
+	return resp, resp.Data
+}
+
+// normalizeMsgpackTree turns the integers of a tree decoded from MessagePack,
+// which may be of any size, into int64.
+func normalizeMsgpackTree(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalizeMsgpackTree(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeMsgpackTree(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func decodeTestProtoResponse(t *testing.T, b []byte) WsResponse {
+	t.Helper()
+
+	var resp WsResponse
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("decoding protobuf response: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch {
+		case num == 3 && typ == protowire.BytesType:
+			var action string
+			action, n = protowire.ConsumeString(b)
+			resp.Action = WsAction(action)
+		case num == 4 && typ == protowire.VarintType:
+			var timestamp uint64
+			timestamp, n = protowire.ConsumeVarint(b)
+			resp.Timestamp = int64(timestamp)
+		case num == 5 && typ == protowire.BytesType:
+			var data []byte
+			data, n = protowire.ConsumeBytes(b)
+			var value structpb.Value
+			if err := proto.Unmarshal(data, &value); err != nil {
+				t.Fatalf("decoding protobuf data: %v", err)
+			}
+			resp.Data = fromProtoValue(value.AsInterface())
+		case num == 7 && typ == protowire.BytesType:
+			resp.ID, n = protowire.ConsumeString(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			t.Fatalf("decoding protobuf response: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+
+	return resp
+}
+
+// fromProtoValue turns the integral numbers of a google.protobuf.Value tree
+// into int64, as a client would for the fields it knows to be integers.
+func fromProtoValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v)
+		}
+		return v
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = fromProtoValue(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = fromProtoValue(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func TestCodecsEncodeTheSameData(t *testing.T) {
+	data := newTestCodecData()
+	createdAt := data.CreatedAt.Format(time.RFC3339Nano)
+
+	for _, codec := range wsCodecs {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			original := newTestCodecResponse()
+			b, err := codec.EncodeResponse(original)
+			if err != nil {
+				t.Fatalf("EncodeResponse() error = %v", err)
+			}
+
+			resp, tree := decodeTestResponse(t, codec, b)
+			if resp.Action != original.Action || resp.Timestamp != original.Timestamp || resp.ID != original.ID {
+				t.Errorf("decoded response %+v, want action %s, timestamp %d and ID %s", resp, original.Action, original.Timestamp, original.ID)
+			}
+
+			fields, ok := tree.(map[string]interface{})
+			if !ok {
+				t.Fatalf("data decoded as %T, want a map", tree)
+			}
+
+			// only protobuf cannot carry the large ID as a number
+			wantId := interface{}(testCodecBigID)
+			if codec == protobufCodec {
+				wantId = "1152921504606846977"
+			}
+
+			want := map[string]interface{}{
+				"id":         wantId,
+				"seq":        int64(42),
+				"ratio":      0.5,
+				"text":       "hello",
+				"tags":       []interface{}{"a", "b"},
+				"created_at": createdAt,
+				"deleted":    nil,
+			}
+			if !reflect.DeepEqual(fields, want) {
+				t.Errorf("data decoded as %#v, want %#v", fields, want)
+			}
+		})
+	}
+}
+
+func TestCodecsEncodeResponsesFromTheBroker(t *testing.T) {
+	b, err := json.Marshal(newTestCodecResponse())
+	if err != nil {
+		t.Fatalf("encoding response: %v", err)
+	}
+
+	for _, codec := range wsCodecs {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			direct, err := codec.EncodeResponse(newTestCodecResponse())
+			if err != nil {
+				t.Fatalf("EncodeResponse() error = %v", err)
+			}
+
+			relayed, err := newWsOutboundJSON(b).encode(codec)
+			if err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+
+			_, want := decodeTestResponse(t, codec, direct)
+			_, got := decodeTestResponse(t, codec, relayed)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("relayed data decoded as %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestCodecsDecodeParameters(t *testing.T) {
+	type params struct {
+		ConversationID int64     `json:"conversation_id"`
+		Message        string    `json:"message"`
+		SentAt         time.Time `json:"sent_at"`
+	}
+
+	sentAt := time.Date(2026, 10, 18, 12, 30, 45, 0, time.UTC)
+	tree := map[string]interface{}{
+		"conversation_id": 9007199254740991,
+		"message":         "hello",
+		"sent_at":         sentAt.Format(time.RFC3339),
+	}
+
+	encoded := map[wsCodec]func() ([]byte, error){
+		jsonCodec: func() ([]byte, error) {
+			return json.Marshal(tree)
+		},
+		msgpackCodec: func() ([]byte, error) {
+			return msgpack.Marshal(tree)
+		},
+		protobufCodec: func() ([]byte, error) {
+			value, err := structpb.NewValue(tree)
+			if err != nil {
+				return nil, err
+			}
+			return proto.Marshal(value)
+		},
+	}
+
+	for _, codec := range wsCodecs {
+		t.Run(codec.Subprotocol(), func(t *testing.T) {
+			b, err := encoded[codec]()
+			if err != nil {
+				t.Fatalf("encoding parameters: %v", err)
+			}
+
+			var got params
+			if err := codec.DecodeParameters(b, &got); err != nil {
+				t.Fatalf("DecodeParameters() error = %v", err)
+			}
+
+			want := params{ConversationID: 9007199254740991, Message: "hello", SentAt: sentAt}
+			if got != want {
+				t.Errorf("DecodeParameters() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
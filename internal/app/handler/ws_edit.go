@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+type EditMessageParams struct {
+	ConversationID int64  `json:"conversation_id"`
+	MessageID      int64  `json:"message_id"`
+	Message        string `json:"message"` // New text of the message
+}
+
+// validate checks the parameters of an edit, the conversation and the
+// message excepted.
+func (p *EditMessageParams) validate() error {
+	if strings.TrimSpace(p.Message) == "" {
+		return fmt.Errorf("message must not be empty")
+	}
+
+	return nil
+}
+
+// handleEditMessage replaces the text of a message the client's user sent.
+func (h *WsHandler) handleEditMessage(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params EditMessageParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if params.ConversationID <= 0 {
+		return nil, wsValidationError("conversation_id is required")
+	}
+
+	if params.MessageID <= 0 {
+		return nil, wsValidationError("message_id is required")
+	}
+
+	if err := params.validate(); err != nil {
+		return nil, wsValidationError("%s", err)
+	}
+
+	if err := h.requireParticipant(client, params.ConversationID); err != nil {
+		return nil, err
+	}
+
+	edited, err := h.editMessage(client.UserID, client.ID, params)
+	if err != nil {
+		switch {
+		case models.IsNotFoundError(err):
+			return nil, wsValidationError("Message %d not found in conversation %d", params.MessageID, params.ConversationID)
+		case models.IsPermissionDeniedError(err):
+			return nil, wsError(WsErrorCodeForbidden, "%s", err)
+		}
+		return nil, err
+	}
+
+	return edited, nil
+}
+
+// editMessage replaces the text of a message the user sent and tells the
+// subscribers of its conversation, the origin connection excepted. params
+// must be valid and the user a participant of the conversation.
+func (h *WsHandler) editMessage(userId string, origin string, params EditMessageParams) (domain.Message, error) {
+	edited, err := h.chatUsecase.EditMessage(params.ConversationID, params.MessageID, userId, params.Message, h.globalConfig.Messages.EditWindow)
+	if err != nil {
+		return domain.Message{}, err
+	}
+
+	event, err := json.Marshal(WsEventResponse(ActionMessageEdited, edited))
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding message edited event")
+		return edited, nil
+	}
+
+	h.publish(conversationChannel(params.ConversationID), brokerEnvelope{
+		Origin:  origin,
+		Payload: event,
+	})
+
+	return edited, nil
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+)
+
+// pushMembership broadcasts a change to the participants of a group to the
+// clients subscribed to it. The users who were added get it on every
+// connection, not being subscribed yet, and the connections of those who
+// left or were removed stop receiving the events of the group.
+func (h *WsHandler) pushMembership(event domain.MembershipEvent) {
+	if event.Message == nil {
+		// nothing changed, or nobody is left to tell
+		return
+	}
+
+	payload, err := json.Marshal(WsEventResponse(ActionMembership, event))
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding membership event")
+		return
+	}
+
+	envelope := brokerEnvelope{
+		Seq:       event.Message.Seq,
+		StreamSeq: event.Message.StreamSeq,
+		Payload:   payload,
+	}
+
+	switch event.Change {
+	case domain.MembershipAdded:
+		for _, userId := range event.UserIDs {
+			h.Send2User(userId, "", payload)
+		}
+	case domain.MembershipRemoved, domain.MembershipLeft:
+		envelope.Unsubscribe = event.UserIDs
+	}
+
+	h.publish(conversationChannel(event.ConversationID), envelope)
+}
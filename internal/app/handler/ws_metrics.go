@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// wsMetrics are the metrics of the realtime connections, over WebSocket and
+// event streams alike. Instruments that cannot be created are replaced by
+// no-op ones.
+type wsMetrics struct {
+	activeConnections metric.Int64UpDownCounter
+	connects          metric.Int64Counter
+	disconnects       metric.Int64Counter
+	inbound           metric.Int64Counter
+	outbound          metric.Int64Counter
+	dropped           metric.Int64Counter
+	fanoutLatency     metric.Float64Histogram
+	queueDepth        metric.Int64Histogram
+}
+
+func newWsMetrics() *wsMetrics {
+	meter := otel.Meter("gomess")
+	m := &wsMetrics{}
+
+	var err error
+	if m.activeConnections, err = meter.Int64UpDownCounter(
+		"ws_active_connections",
+		metric.WithDescription("Number of open realtime connections"),
+	); err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_active_connections counter metric")
+		m.activeConnections = noop.Int64UpDownCounter{}
+	}
+
+	if m.connects, err = meter.Int64Counter(
+		"ws_connects",
+		metric.WithDescription("Number of realtime connections opened"),
+	); err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_connects counter metric")
+		m.connects = noop.Int64Counter{}
+	}
+
+	if m.disconnects, err = meter.Int64Counter(
+		"ws_disconnects",
+		metric.WithDescription("Number of realtime connections closed, by close reason"),
+	); err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_disconnects counter metric")
+		m.disconnects = noop.Int64Counter{}
+	}
+
+	if m.inbound, err = meter.Int64Counter(
+		"ws_inbound_messages",
+		metric.WithDescription("Number of messages received from realtime clients, by action"),
+	); err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_inbound_messages counter metric")
+		m.inbound = noop.Int64Counter{}
+	}
+
+	if m.outbound, err = meter.Int64Counter(
+		"ws_outbound_messages",
+		metric.WithDescription("Number of messages written to realtime clients, by action"),
+	); err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_outbound_messages counter metric")
+		m.outbound = noop.Int64Counter{}
+	}
+
+	if m.dropped, err = meter.Int64Counter(
+		"ws_dropped_messages",
+		metric.WithDescription("Number of messages never written to realtime clients, by reason"),
+	); err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_dropped_messages counter metric")
+		m.dropped = noop.Int64Counter{}
+	}
+
+	if m.fanoutLatency, err = meter.Float64Histogram(
+		"ws_fanout_latency",
+		metric.WithDescription("Time from the publication of an event to its delivery to the local clients"),
+		metric.WithUnit("ms"),
+	); err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_fanout_latency histogram metric")
+		m.fanoutLatency = noop.Float64Histogram{}
+	}
+
+	if m.queueDepth, err = meter.Int64Histogram(
+		"ws_queue_depth",
+		metric.WithDescription("Number of outbound messages queued for a client when one is added"),
+	); err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_queue_depth histogram metric")
+		m.queueDepth = noop.Int64Histogram{}
+	}
+
+	return m
+}
+
+func (m *wsMetrics) connected(transport string) {
+	attrs := metric.WithAttributes(attribute.String("transport", transport))
+	m.connects.Add(context.Background(), 1, attrs)
+	m.activeConnections.Add(context.Background(), 1, attrs)
+}
+
+func (m *wsMetrics) disconnected(transport, reason string) {
+	m.activeConnections.Add(context.Background(), -1, metric.WithAttributes(attribute.String("transport", transport)))
+	m.disconnects.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("transport", transport),
+		attribute.String("reason", reason),
+	))
+}
+
+func (m *wsMetrics) received(action WsAction) {
+	m.inbound.Add(context.Background(), 1, metric.WithAttributes(attribute.String("action", string(action))))
+}
+
+func (m *wsMetrics) written(action WsAction) {
+	m.outbound.Add(context.Background(), 1, metric.WithAttributes(attribute.String("action", string(action))))
+}
+
+func (m *wsMetrics) drop(reason string) {
+	m.dropped.Add(context.Background(), 1, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+func (m *wsMetrics) queued(depth int) {
+	m.queueDepth.Record(context.Background(), int64(depth))
+}
+
+// fannedOut records how long an event published at sentAt, as Unix
+// nanoseconds, took to reach this instance. Events of other instances are
+// subject to clock skew.
+func (m *wsMetrics) fannedOut(sentAt int64) {
+	if sentAt == 0 {
+		return
+	}
+
+	m.fanoutLatency.Record(context.Background(), float64(time.Since(time.Unix(0, sentAt)))/float64(time.Millisecond))
+}
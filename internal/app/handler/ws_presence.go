@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+)
+
+type SetPresenceParams struct {
+	Status domain.PresenceStatus `json:"status"` // One of online, away, dnd and invisible
+}
+
+func (h *WsHandler) handleSetPresence(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params SetPresenceParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if !params.Status.IsSelectable() {
+		return nil, wsValidationError("Unsupported presence status %q", params.Status)
+	}
+
+	presence, err := h.userUsecase.UpdateUserStatus(client.UserID, params.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	// the other devices of the user learn the actual status, peers the
+	// one they are allowed to see
+	h.sendPresence(client.UserID, client.ID, presence)
+	h.broadcastPresence(presence.Visible(true))
+
+	return presence, nil
+}
+
+// presenceConnected records that the user of client is connected, and tells
+// its peers once it opens its first connection across every instance.
+func (h *WsHandler) presenceConnected(client *WsClient, firstConnection bool) {
+	log := logrus.WithField("user_id", client.UserID)
+
+	if err := h.userUsecase.UpdateLastSeen(client.UserID, time.Now()); err != nil {
+		log.WithError(err).Error("Error updating last seen")
+	}
+
+	if !firstConnection {
+		return
+	}
+
+	select {
+	case <-client.done:
+		// the connection closed meanwhile and told the peers already
+		return
+	default:
+	}
+
+	presences, err := h.userUsecase.Presences([]string{client.UserID})
+	if err != nil {
+		log.WithError(err).Error("Error getting presence")
+		return
+	}
+
+	if visible := presences[0].Visible(true); visible.Status != domain.PresenceOffline {
+		h.broadcastPresence(visible)
+	}
+}
+
+// presenceDisconnected records when the user of client was last seen, and
+// tells its peers once it has closed its last connection across every
+// instance.
+func (h *WsHandler) presenceDisconnected(client *WsClient) {
+	log := logrus.WithField("user_id", client.UserID)
+
+	servers, err := h.broker.LookupClient(context.Background(), client.UserID)
+	if err != nil {
+		log.WithError(err).Error("Error looking up client in broker")
+		return
+	}
+	if len(servers) > 0 {
+		return
+	}
+
+	now := time.Now()
+	if err := h.userUsecase.UpdateLastSeen(client.UserID, now); err != nil {
+		log.WithError(err).Error("Error updating last seen")
+	}
+
+	presences, err := h.userUsecase.Presences([]string{client.UserID})
+	if err != nil {
+		log.WithError(err).Error("Error getting presence")
+		return
+	}
+
+	// invisible users were seen offline already, and must not give away
+	// when they leave
+	if presences[0].Status == domain.PresenceInvisible {
+		return
+	}
+
+	presences[0].LastSeenAt = &now
+	h.broadcastPresence(presences[0].Visible(false))
+}
+
+// broadcastPresence sends presence to every user sharing a conversation with
+// its user, on whichever instance they are connected to.
+func (h *WsHandler) broadcastPresence(presence domain.Presence) {
+	peers, err := h.chatUsecase.PeerIDs(presence.UserID)
+	if err != nil {
+		logrus.WithError(err).WithField("user_id", presence.UserID).Error("Error finding conversation peers")
+		return
+	}
+
+	event, err := json.Marshal(WsEventResponse(ActionPresence, presence))
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding presence event")
+		return
+	}
+
+	h.send2Users(peers, "", event)
+}
+
+// sendPresence sends presence to the connections of userId, except clientId.
+func (h *WsHandler) sendPresence(userId, clientId string, presence domain.Presence) {
+	event, err := json.Marshal(WsEventResponse(ActionPresence, presence))
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding presence event")
+		return
+	}
+
+	h.Send2User(userId, clientId, event)
+}
+
+// visiblePresences returns the presence of the users as others see them.
+func (h *WsHandler) visiblePresences(ctx context.Context, userIds []string) ([]domain.Presence, error) {
+	presences, err := h.userUsecase.Presences(userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	servers, err := h.broker.LookupClients(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, presence := range presences {
+		presences[i] = presence.Visible(len(servers[presence.UserID]) > 0)
+	}
+
+	return presences, nil
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/notifier"
+)
+
+// PublishTarget selects the recipients of a published event: the users listed
+// or the clients subscribed to a conversation.
+type PublishTarget struct {
+	UserIDs        []string
+	ConversationID int64
+}
+
+type PublishResult struct {
+	Delivered int      `json:"delivered"`        // Number of live connections the event was written to
+	Offline   []string `json:"offline_user_ids"` // Recipients connected to no instance, handed to the notifier
+}
+
+// ServerEvent is an event published by a backend service.
+type ServerEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// brokerReply is sent back by every instance an envelope with a reply topic
+// was delivered on.
+type brokerReply struct {
+	Delivered map[string]int `json:"delivered"` // Number of connections that got it, by user
+}
+
+// Publish pushes an event to the target and returns how many live connections
+// received it, across every instance. The recipients the broker knows no
+// connection of get it through the notifier instead, the others are online
+// even when none of their connections received it. For a conversation the recipients are its
+// participants, the connections those subscribed to it, and a
+// ConversationNotFoundError is returned when it does not exist.
+func (h *WsHandler) Publish(ctx context.Context, target PublishTarget, event ServerEvent) (PublishResult, error) {
+	var recipients []string
+	if target.ConversationID > 0 {
+		participants, err := h.chatUsecase.ParticipantIDs(target.ConversationID)
+		if err != nil {
+			return PublishResult{}, err
+		}
+		recipients = participants
+	} else {
+		recipients = target.UserIDs
+	}
+
+	payload, err := json.Marshal(WsEventResponse(ActionServerEvent, event))
+	if err != nil {
+		return PublishResult{}, errors.Wrap(err, "encoding server event")
+	}
+
+	// look every recipient up first so that the number of replies to wait
+	// for is known
+	servers, err := h.broker.LookupClients(ctx, recipients)
+	if err != nil {
+		return PublishResult{}, errors.Wrap(err, "looking up recipients")
+	}
+
+	envelopes := make(map[string][]brokerEnvelope)
+	for _, userId := range recipients {
+		for _, serverId := range servers[userId] {
+			if target.ConversationID > 0 {
+				// a single envelope per instance reaches every
+				// local subscriber of the conversation
+				if _, ok := envelopes[serverId]; !ok {
+					envelopes[serverId] = []brokerEnvelope{{Channel: conversationChannel(target.ConversationID)}}
+				}
+				continue
+			}
+			envelopes[serverId] = append(envelopes[serverId], brokerEnvelope{Target: userId})
+		}
+	}
+
+	expected := 0
+	for _, serverEnvelopes := range envelopes {
+		expected += len(serverEnvelopes)
+	}
+
+	delivered := make(map[string]int)
+	if expected > 0 {
+		replyTopic := "reply:" + uuid.Must(uuid.NewV4()).String()
+		replies := make(chan brokerReply, expected)
+		if err := h.broker.Subscribe(ctx, replyTopic, func(payload []byte) {
+			var reply brokerReply
+			if err := json.Unmarshal(payload, &reply); err != nil {
+				logrus.WithError(err).Error("Error decoding broker reply")
+				return
+			}
+			select {
+			case replies <- reply:
+			default:
+			}
+		}); err != nil {
+			return PublishResult{}, errors.Wrap(err, "subscribing to replies")
+		}
+		defer func() {
+			if err := h.broker.Unsubscribe(context.Background(), replyTopic); err != nil {
+				logrus.WithError(err).WithField("topic", replyTopic).Error("Error unsubscribing from broker")
+			}
+		}()
+
+		for serverId, serverEnvelopes := range envelopes {
+			for _, envelope := range serverEnvelopes {
+				envelope.ReplyTo = replyTopic
+				envelope.Payload = payload
+				h.publish(serverTopic(serverId), envelope)
+			}
+		}
+
+		// instances that do not reply in time, e.g. because they went
+		// away, count as having delivered to nobody
+		waitCtx, cancel := context.WithTimeout(ctx, h.globalConfig.API.WebSocket.PublishTimeout)
+		defer cancel()
+
+	wait:
+		for received := 0; received < expected; received++ {
+			select {
+			case reply := <-replies:
+				for userId, n := range reply.Delivered {
+					delivered[userId] += n
+				}
+			case <-waitCtx.Done():
+				logrus.WithField("missing", expected-received).Warn("Instances did not reply to a publish in time")
+				break wait
+			}
+		}
+	}
+
+	result := PublishResult{Offline: []string{}}
+	for _, userId := range recipients {
+		result.Delivered += delivered[userId]
+		if len(servers[userId]) > 0 {
+			continue
+		}
+
+		result.Offline = append(result.Offline, userId)
+		if err := h.notifier.Notify(ctx, notifier.Notification{
+			UserID: userId,
+			Event:  event.Event,
+			Data:   event.Data,
+		}); err != nil {
+			logrus.WithError(err).WithField("user_id", userId).Error("Error notifying offline user")
+		}
+	}
+
+	return result, nil
+}
+
+// replyDelivered tells the publisher of an envelope how many local connections
+// it was delivered to.
+func (h *WsHandler) replyDelivered(envelope brokerEnvelope, delivered map[string]int) {
+	if envelope.ReplyTo == "" {
+		return
+	}
+
+	b, err := json.Marshal(brokerReply{Delivered: delivered})
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding broker reply")
+		return
+	}
+
+	if err := h.broker.Publish(context.Background(), envelope.ReplyTo, b); err != nil {
+		logrus.WithError(err).WithField("topic", envelope.ReplyTo).Error("Error publishing to broker")
+	}
+}
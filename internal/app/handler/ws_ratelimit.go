@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+// wsAnyAction is the rate limit key of the actions without a limit of their
+// own, unknown actions included.
+const wsAnyAction WsAction = "*"
+
+// Limits a client can break, as counted in the ws_limit_violations metric.
+const (
+	wsLimitConnectionRate = "connection_rate"
+	wsLimitUserRate       = "user_rate"
+	wsLimitMessageSize    = "message_size"
+)
+
+// wsLimiterSweepInterval is how often the token buckets of users are swept.
+const wsLimiterSweepInterval = time.Minute
+
+// wsRateLimiter limits how many messages per second each connection, and each
+// user across its connections to this instance, may send per action, with
+// token buckets. The buckets of a user outlive its connections, so that
+// reconnecting does not refill them, and are dropped once refilled. It is
+// safe for concurrent use.
+type wsRateLimiter struct {
+	sync.Mutex
+
+	config     *config.WebSocketConfiguration
+	users      map[string]map[WsAction]*rate.Limiter
+	nextSweep  time.Time
+	violations metric.Int64Counter
+}
+
+func newWsRateLimiter(wsConfig *config.WebSocketConfiguration) *wsRateLimiter {
+	meter := otel.Meter("gomess")
+	violations, err := meter.Int64Counter(
+		"ws_limit_violations",
+		metric.WithDescription("Number of WebSocket messages rejected for breaking a rate or size limit"),
+	)
+	if err != nil {
+		logrus.WithError(err).Error("unable to get gomess.ws_limit_violations counter metric")
+	}
+
+	return &wsRateLimiter{
+		config:     wsConfig,
+		users:      make(map[string]map[WsAction]*rate.Limiter),
+		violations: violations,
+	}
+}
+
+// newLimiter returns the token bucket of an action given its limits, nil when
+// the action is not limited.
+func (l *wsRateLimiter) newLimiter(limits map[string]float64, action WsAction) *rate.Limiter {
+	limit, ok := limits[string(action)]
+	if !ok {
+		limit = limits[string(wsAnyAction)]
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	burst := int(math.Ceil(limit * l.config.RateLimitBurst.Seconds()))
+	if burst < 1 {
+		burst = 1
+	}
+
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+// allow reports whether client may send a message with action key now, and
+// otherwise which limit it broke.
+func (l *wsRateLimiter) allow(client *WsClient, key WsAction) (bool, string) {
+	client.limitersMu.Lock()
+	if client.limiters == nil {
+		client.limiters = make(map[WsAction]*rate.Limiter)
+	}
+	limiter, ok := client.limiters[key]
+	if !ok {
+		limiter = l.newLimiter(l.config.ConnectionRateLimits, key)
+		client.limiters[key] = limiter
+	}
+	client.limitersMu.Unlock()
+
+	if limiter != nil && !limiter.Allow() {
+		return false, wsLimitConnectionRate
+	}
+
+	l.Lock()
+	l.sweep(time.Now())
+	limiters, ok := l.users[client.UserID]
+	if !ok {
+		limiters = make(map[WsAction]*rate.Limiter)
+		l.users[client.UserID] = limiters
+	}
+	limiter, ok = limiters[key]
+	if !ok {
+		limiter = l.newLimiter(l.config.UserRateLimits, key)
+		limiters[key] = limiter
+	}
+	l.Unlock()
+
+	if limiter != nil && !limiter.Allow() {
+		return false, wsLimitUserRate
+	}
+
+	return true, ""
+}
+
+// violated records that client broke limit, and reports whether it did so too
+// often and must be disconnected.
+func (l *wsRateLimiter) violated(client *WsClient, action WsAction, limit string) bool {
+	logrus.WithFields(logrus.Fields{
+		"client_id": client.ID,
+		"user_id":   client.UserID,
+		"action":    action,
+		"limit":     limit,
+	}).Warn("WebSocket client exceeded a limit")
+
+	if l.violations != nil {
+		l.violations.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("limit", limit),
+			attribute.String("action", string(action)),
+		))
+	}
+
+	if l.config.MaxRateLimitViolations <= 0 {
+		return false
+	}
+
+	client.limitersMu.Lock()
+	defer client.limitersMu.Unlock()
+
+	if client.violations == nil {
+		perMinute := rate.Every(time.Minute / time.Duration(l.config.MaxRateLimitViolations))
+		client.violations = rate.NewLimiter(perMinute, l.config.MaxRateLimitViolations)
+	}
+
+	return !client.violations.Allow()
+}
+
+// sweep drops the token buckets of the users whose buckets are all full
+// again, which new buckets would be as well, at most once per
+// wsLimiterSweepInterval. The caller must hold the lock.
+func (l *wsRateLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(wsLimiterSweepInterval)
+
+	for userId, limiters := range l.users {
+		full := true
+		for _, limiter := range limiters {
+			if limiter != nil && limiter.TokensAt(now) < float64(limiter.Burst()) {
+				full = false
+				break
+			}
+		}
+		if full {
+			delete(l.users, userId)
+		}
+	}
+}
+
+// rateLimit checks the rate limits of the action of msg for client. It
+// returns the error to reply with when they are exceeded, and whether the
+// client broke them too often and is being disconnected.
+func (h *WsHandler) rateLimit(client *WsClient, msg *WsMessage) (*WsError, bool) {
+	key := msg.Action
+	if _, ok := h.actions[key]; !ok {
+		key = wsAnyAction
+	}
+
+	ok, limit := h.limiter.allow(client, key)
+	if ok {
+		return nil, false
+	}
+
+	err := wsError(WsErrorCodeOverRequestRateLimit, "Too many %s messages, please slow down", msg.Action).WithDetails(string(ErrorCodeOverRequestRateLimit))
+	return err, h.limiter.violated(client, msg.Action, limit)
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+type MarkReadParams struct {
+	ConversationID int64 `json:"conversation_id"`
+	Seq            int64 `json:"seq"` // Sequence number of the latest message read
+}
+
+// handleMarkRead records that the client read the messages of a conversation
+// up to the given sequence number.
+func (h *WsHandler) handleMarkRead(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params MarkReadParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if params.ConversationID <= 0 {
+		return nil, wsValidationError("conversation_id is required")
+	}
+
+	if params.Seq <= 0 {
+		return nil, wsValidationError("seq must be positive")
+	}
+
+	receipt, senders, err := h.chatUsecase.MarkRead(params.ConversationID, client.UserID, params.Seq)
+	if err != nil {
+		if models.IsNotFoundError(err) {
+			return nil, wsError(WsErrorCodeForbidden, "Not a participant of conversation %d", params.ConversationID)
+		}
+		return nil, err
+	}
+	h.pushReceipt(receipt, senders, client.ID)
+
+	return receipt, nil
+}
+
+// pushReceipt tells the senders of the messages a receipt covers that they
+// were delivered or read. clientId is the connection the receipt came from,
+// empty when it came from the REST API.
+func (h *WsHandler) pushReceipt(receipt domain.Receipt, senders []string, clientId string) {
+	if len(senders) == 0 {
+		return
+	}
+
+	event, err := json.Marshal(WsEventResponse(ActionReceipt, receipt))
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding receipt event")
+		return
+	}
+
+	for _, senderId := range senders {
+		h.Send2User(senderId, clientId, event)
+	}
+}
@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+)
+
+type ResumeCursor struct {
+	ConversationID int64 `json:"conversation_id"`
+	LastSeq        int64 `json:"last_seq"` // Sequence number of the last message received
+}
+
+type ResumeParams struct {
+	// Conversations resumes each conversation from its own sequence
+	// number, Cursor resumes every conversation of the user from the
+	// stream sequence number of the last message received, which orders
+	// messages as they were committed. Only one of them may be set.
+	Conversations []ResumeCursor `json:"conversations"`
+	Cursor        *int64         `json:"cursor"`
+}
+
+type ResumeConversation struct {
+	ConversationID int64 `json:"conversation_id"`
+	Replayed       int   `json:"replayed"`                  // Number of messages replayed
+	LastSeq        int64 `json:"last_seq"`                  // Sequence number the client is up to once replayed
+	ResyncRequired bool  `json:"resync_required,omitempty"` // Too many messages were missed, the client must fetch the conversation again
+}
+
+type ResumeResult struct {
+	Conversations  []ResumeConversation `json:"conversations"`
+	Cursor         *int64               `json:"cursor,omitempty"`          // Stream sequence number the client is up to once replayed
+	ResyncRequired bool                 `json:"resync_required,omitempty"` // Too many messages were missed, the client must fetch every conversation again
+}
+
+// ResumeReplay is pushed with the missed messages of a conversation before the
+// reply to a resume.
+type ResumeReplay struct {
+	ConversationID int64            `json:"conversation_id"`
+	Messages       []domain.Message `json:"messages"`
+}
+
+// handleResume subscribes the client to its conversations again after a
+// reconnect and replays the messages it missed meanwhile. The live events of
+// the conversations are held during the replay and released once the reply is
+// sent, so that they follow both.
+//
+// Only what is stored as a message is replayed. That includes membership
+// changes, which are system messages, and the current text of the missed
+// messages. The other events sent while the client was away are not: edits
+// of messages it had already got, receipts, typing and presence. A client
+// catches up on those by fetching the history and receipts of the
+// conversation again.
+func (h *WsHandler) handleResume(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params ResumeParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	var (
+		result   ResumeResult
+		replayed = make(map[string]int64)
+		err      error
+	)
+	switch {
+	case params.Cursor != nil && len(params.Conversations) > 0:
+		return nil, wsValidationError("Only one of conversations and cursor may be set")
+
+	case params.Cursor != nil:
+		if *params.Cursor < 0 {
+			return nil, wsValidationError("cursor must not be negative")
+		}
+		result, err = h.resumeFromCursor(client, *params.Cursor, replayed)
+
+	case len(params.Conversations) > 0:
+		result, err = h.resumeConversations(client, params.Conversations, replayed)
+
+	default:
+		return nil, wsValidationError("conversations or cursor is required")
+	}
+
+	if err != nil {
+		client.releaseAll(replayed)
+		return nil, err
+	}
+
+	return &wsReply{data: result, afterSend: func() {
+		client.releaseAll(replayed)
+	}}, nil
+}
+
+// resumeConversations replays each conversation from its cursor. The channels
+// held are added to replayed along with the sequence number they are replayed
+// up to.
+func (h *WsHandler) resumeConversations(client *WsClient, cursors []ResumeCursor, replayed map[string]int64) (ResumeResult, error) {
+	for _, cursor := range cursors {
+		if cursor.ConversationID <= 0 {
+			return ResumeResult{}, wsValidationError("conversation_id is required")
+		}
+
+		if cursor.LastSeq < 0 {
+			return ResumeResult{}, wsValidationError("last_seq must not be negative")
+		}
+
+		if err := h.requireParticipant(client, cursor.ConversationID); err != nil {
+			return ResumeResult{}, err
+		}
+	}
+
+	for _, cursor := range cursors {
+		channel := conversationChannel(cursor.ConversationID)
+		client.hold(channel)
+		replayed[channel] = cursor.LastSeq
+	}
+
+	limit := h.globalConfig.API.WebSocket.ReplayLimit
+	result := ResumeResult{Conversations: make([]ResumeConversation, 0, len(cursors))}
+	for _, cursor := range cursors {
+		channel := conversationChannel(cursor.ConversationID)
+		if err := h.channels.subscribe(channel, client); err != nil {
+			return ResumeResult{}, err
+		}
+
+		messages, err := h.chatUsecase.MissedMessages(cursor.ConversationID, cursor.LastSeq, limit+1)
+		if err != nil {
+			return ResumeResult{}, err
+		}
+
+		conversation := ResumeConversation{ConversationID: cursor.ConversationID, LastSeq: cursor.LastSeq}
+		if len(messages) > limit {
+			conversation.ResyncRequired = true
+		} else {
+			conversation.Replayed = len(messages)
+			conversation.LastSeq = client.replay(cursor.ConversationID, messages, cursor.LastSeq)
+			replayed[channel] = conversation.LastSeq
+		}
+		result.Conversations = append(result.Conversations, conversation)
+	}
+
+	return result, nil
+}
+
+// resumeFromCursor replays every conversation of the user from the ID of the
+// last message received, holding their channels as resumeConversations does.
+func (h *WsHandler) resumeFromCursor(client *WsClient, cursor int64, replayed map[string]int64) (ResumeResult, error) {
+	conversationIds, err := h.chatUsecase.ConversationIDs(client.UserID)
+	if err != nil {
+		return ResumeResult{}, err
+	}
+
+	for _, conversationId := range conversationIds {
+		channel := conversationChannel(conversationId)
+		client.hold(channel)
+		replayed[channel] = 0
+	}
+
+	for _, conversationId := range conversationIds {
+		if err := h.channels.subscribe(conversationChannel(conversationId), client); err != nil {
+			return ResumeResult{}, err
+		}
+	}
+
+	limit := h.globalConfig.API.WebSocket.ReplayLimit
+	messages, err := h.chatUsecase.MissedUserMessages(client.UserID, cursor, limit+1)
+	if err != nil {
+		return ResumeResult{}, err
+	}
+
+	result := ResumeResult{Conversations: []ResumeConversation{}, Cursor: &cursor}
+	if len(messages) > limit {
+		result.ResyncRequired = true
+		return result, nil
+	}
+
+	// group the messages by conversation, in the order the conversations
+	// got their first missed message
+	var order []int64
+	missed := make(map[int64][]domain.Message)
+	for _, message := range messages {
+		if _, ok := missed[message.ConversationID]; !ok {
+			order = append(order, message.ConversationID)
+		}
+		missed[message.ConversationID] = append(missed[message.ConversationID], message)
+	}
+
+	for _, conversationId := range order {
+		lastSeq := client.replay(conversationId, missed[conversationId], 0)
+		replayed[conversationChannel(conversationId)] = lastSeq
+
+		result.Conversations = append(result.Conversations, ResumeConversation{
+			ConversationID: conversationId,
+			Replayed:       len(missed[conversationId]),
+			LastSeq:        lastSeq,
+		})
+	}
+
+	if len(messages) > 0 {
+		last := messages[len(messages)-1].StreamSeq
+		result.Cursor = &last
+	}
+
+	return result, nil
+}
+
+// replay pushes the missed messages of a conversation to the client and
+// returns the sequence number it is up to, lastSeq when there are none.
+func (c *WsClient) replay(conversationId int64, messages []domain.Message, lastSeq int64) int64 {
+	if len(messages) == 0 {
+		return lastSeq
+	}
+
+	out := newWsOutbound(WsEventResponse(ActionResume, ResumeReplay{
+		ConversationID: conversationId,
+		Messages:       messages,
+	}))
+	out.cursor = messages[len(messages)-1].StreamSeq
+	c.Send(out)
+
+	return messages[len(messages)-1].Seq
+}
+
+// deliver queues a live event of channel for the client, unless the channel
+// is being replayed in which case the event is held until the replay is over.
+func (c *WsClient) deliver(channel string, message *wsOutbound) {
+	c.heldMu.Lock()
+	if held, ok := c.held[channel]; ok {
+		c.held[channel] = append(held, message)
+		c.heldMu.Unlock()
+		return
+	}
+	c.heldMu.Unlock()
+
+	c.Send(message)
+}
+
+// hold starts holding the live events of channel.
+func (c *WsClient) hold(channel string) {
+	c.heldMu.Lock()
+	defer c.heldMu.Unlock()
+
+	if c.held == nil {
+		c.held = make(map[string][]*wsOutbound)
+	}
+	if _, ok := c.held[channel]; !ok {
+		c.held[channel] = nil
+	}
+}
+
+// releaseAll queues the events held for each channel, except those carrying
+// a message replayed already, i.e. up to the sequence number of the channel,
+// and resumes live delivery.
+func (c *WsClient) releaseAll(replayedSeqs map[string]int64) {
+	c.heldMu.Lock()
+	defer c.heldMu.Unlock()
+
+	for channel, replayedSeq := range replayedSeqs {
+		for _, message := range c.held[channel] {
+			if message.seq == 0 || message.seq > replayedSeq {
+				c.Send(message)
+			}
+		}
+		delete(c.held, channel)
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// GoingAwayEvent is pushed to every client before the server closes its
+// connection to shut down.
+type GoingAwayEvent struct {
+	// ReconnectAfter is how long the client should wait before
+	// reconnecting, in milliseconds. It differs for every client so that
+	// they do not all reconnect to the remaining instances at once.
+	ReconnectAfter int64 `json:"reconnect_after_ms"`
+}
+
+// Shutdown stops accepting connections, tells every client the server is
+// going away and closes its connection, then waits for the connections to be
+// closed, for at most the configured drain timeout.
+func (h *WsHandler) Shutdown(ctx context.Context) error {
+	h.draining.Store(true)
+
+	// the clients registered after this snapshot see draining set and are
+	// sent away by registerClient
+	clients := h.localClients.all()
+	logrus.WithField("clients", len(clients)).Info("Draining realtime connections")
+
+	for _, client := range clients {
+		h.goAway(client)
+	}
+
+	wsConfig := &h.globalConfig.API.WebSocket
+	ctx, cancel := context.WithTimeout(ctx, wsConfig.DrainTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for h.localClients.count() > 0 {
+		select {
+		case <-ctx.Done():
+			logrus.WithField("clients", h.localClients.count()).Warn("Realtime connections still open after the drain timeout")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// goAway tells the client the server is going away, with a random delay to
+// reconnect after, and closes its connection.
+func (h *WsHandler) goAway(client *WsClient) {
+	client.goingAway.Do(func() {
+		var reconnectAfter time.Duration
+		if jitter := h.globalConfig.API.WebSocket.ReconnectJitter; jitter > 0 {
+			reconnectAfter = time.Duration(rand.Int63n(int64(jitter)))
+		}
+
+		client.sendResponse(WsEventResponse(ActionGoingAway, GoingAwayEvent{
+			ReconnectAfter: reconnectAfter.Milliseconds(),
+		}))
+		client.close(websocket.CloseGoingAway, "server shutting down")
+	})
+}
+
+// rejectWhileDraining fails the requests opening a connection once the server
+// is shutting down, so that clients open it on another instance.
+func (h *WsHandler) rejectWhileDraining(w http.ResponseWriter) error {
+	if !h.draining.Load() {
+		return nil
+	}
+
+	w.Header().Set("Retry-After", "1")
+	return httpError(http.StatusServiceUnavailable, ErrorCodeServerShuttingDown, "Server is shutting down, please reconnect")
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tranminhquanq/gomess/internal/config"
+)
+
+// wsTransport carries the messages written to a client, over a WebSocket
+// connection or a Server-Sent Events stream. Only the write pump of the
+// client uses it.
+type wsTransport interface {
+	// WriteMessage writes message, already encoded with the codec of the
+	// client, before deadline.
+	WriteMessage(message *wsOutbound, data []byte, deadline time.Time) error
+	// WritePing keeps the connection alive and lets dead peers be noticed.
+	WritePing(deadline time.Time) error
+	// WriteClose tells the client why the connection is being closed.
+	WriteClose(code int, text string, deadline time.Time) error
+	Close() error
+}
+
+// wsConnTransport writes to a WebSocket connection.
+type wsConnTransport struct {
+	conn   *websocket.Conn
+	codec  wsCodec
+	config *config.WebSocketConfiguration
+}
+
+func (t *wsConnTransport) WriteMessage(message *wsOutbound, data []byte, deadline time.Time) error {
+	if err := t.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	// compress when the message is large enough for compression to pay
+	// off and the client negotiated it
+	t.conn.EnableWriteCompression(t.config.Compression && len(data) >= t.config.CompressionThreshold)
+
+	return t.conn.WriteMessage(t.codec.FrameType(), data)
+}
+
+func (t *wsConnTransport) WritePing(deadline time.Time) error {
+	if err := t.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	return t.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (t *wsConnTransport) WriteClose(code int, text string, deadline time.Time) error {
+	if err := t.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	return t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+}
+
+func (t *wsConnTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type TypingParams struct {
+	ConversationID int64 `json:"conversation_id"`
+}
+
+// TypingEvent is pushed to the other participants of a conversation when a
+// user starts or stops typing in it.
+type TypingEvent struct {
+	ConversationID int64  `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+}
+
+type typingKey struct {
+	userId         string
+	conversationId int64
+}
+
+type typingState struct {
+	client      *WsClient
+	timer       *time.Timer
+	broadcastAt time.Time
+}
+
+// typingTracker keeps track of who is typing in which conversation, so that
+// typing_start is not broadcast more than once per throttle interval and
+// typing_stop is broadcast once a client stops refreshing it. The state is
+// only kept in memory by the instance the typing client is connected to, so
+// a user typing through clients on several instances is throttled and
+// expired by each of them on its own. It is safe for concurrent use.
+type typingTracker struct {
+	sync.Mutex
+
+	typing map[typingKey]*typingState
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{
+		typing: make(map[typingKey]*typingState),
+	}
+}
+
+func (h *WsHandler) handleTypingStart(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params TypingParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if params.ConversationID <= 0 {
+		return nil, wsValidationError("conversation_id is required")
+	}
+
+	wsConfig := &h.globalConfig.API.WebSocket
+	key := typingKey{userId: client.UserID, conversationId: params.ConversationID}
+
+	// within the throttle interval a start only extends the expiry, which
+	// spares the participant check as well
+	h.typing.Lock()
+	if state, ok := h.typing.typing[key]; ok && time.Since(state.broadcastAt) < wsConfig.TypingThrottle {
+		state.client = client
+		state.timer.Reset(wsConfig.TypingTimeout)
+		h.typing.Unlock()
+		return nil, nil
+	}
+	h.typing.Unlock()
+
+	if err := h.requireParticipant(client, params.ConversationID); err != nil {
+		return nil, err
+	}
+
+	h.typing.Lock()
+	state, ok := h.typing.typing[key]
+	if ok {
+		state.timer.Reset(wsConfig.TypingTimeout)
+	} else {
+		state = &typingState{}
+		state.timer = time.AfterFunc(wsConfig.TypingTimeout, func() {
+			h.expireTyping(key, state)
+		})
+		h.typing.typing[key] = state
+	}
+	state.client = client
+	state.broadcastAt = time.Now()
+	h.typing.Unlock()
+
+	h.broadcastTyping(ActionTypingStart, key)
+
+	return nil, nil
+}
+
+func (h *WsHandler) handleTypingStop(client *WsClient, msg *WsMessage) (interface{}, error) {
+	var params TypingParams
+	if err := decodeParameters(msg, &params); err != nil {
+		return nil, err
+	}
+
+	if params.ConversationID <= 0 {
+		return nil, wsValidationError("conversation_id is required")
+	}
+
+	key := typingKey{userId: client.UserID, conversationId: params.ConversationID}
+
+	h.typing.Lock()
+	state, ok := h.typing.typing[key]
+	if ok {
+		state.timer.Stop()
+		delete(h.typing.typing, key)
+	}
+	h.typing.Unlock()
+
+	// only a user known to be typing, hence a participant, gets its stop
+	// broadcast
+	if ok {
+		h.broadcastTyping(ActionTypingStop, key)
+	}
+
+	return nil, nil
+}
+
+// expireTyping broadcasts typing_stop for a user whose client did not refresh
+// nor stop typing in time.
+func (h *WsHandler) expireTyping(key typingKey, state *typingState) {
+	h.typing.Lock()
+	if h.typing.typing[key] != state {
+		// stopped or restarted meanwhile
+		h.typing.Unlock()
+		return
+	}
+	delete(h.typing.typing, key)
+	h.typing.Unlock()
+
+	h.broadcastTyping(ActionTypingStop, key)
+}
+
+// stopTyping broadcasts typing_stop for every conversation the client was
+// typing in, once it disconnects.
+func (h *WsHandler) stopTyping(client *WsClient) {
+	var stopped []typingKey
+
+	h.typing.Lock()
+	for key, state := range h.typing.typing {
+		if state.client == client {
+			state.timer.Stop()
+			delete(h.typing.typing, key)
+			stopped = append(stopped, key)
+		}
+	}
+	h.typing.Unlock()
+
+	for _, key := range stopped {
+		h.broadcastTyping(ActionTypingStop, key)
+	}
+}
+
+// broadcastTyping pushes a typing event to the participants of the
+// conversation, except to any connection of the typing user.
+func (h *WsHandler) broadcastTyping(action WsAction, key typingKey) {
+	event, err := json.Marshal(WsEventResponse(action, TypingEvent{
+		ConversationID: key.conversationId,
+		UserID:         key.userId,
+	}))
+	if err != nil {
+		logrus.WithError(err).Error("Error encoding typing event")
+		return
+	}
+
+	h.publish(conversationChannel(key.conversationId), brokerEnvelope{ExceptUser: key.userId, Payload: event})
+}
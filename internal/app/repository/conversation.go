@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/models"
+	"github.com/tranminhquanq/gomess/internal/storage"
+)
+
+type ConversationRepositoryImpl struct {
+	db *storage.Connection
+}
+
+func NewConversationRepository(db *storage.Connection) *ConversationRepositoryImpl {
+	return &ConversationRepositoryImpl{db: db}
+}
+
+// CreateConversation stores conversation along with its participants.
+func (repo *ConversationRepositoryImpl) CreateConversation(conversation domain.Conversation) (domain.Conversation, error) {
+	creatorId, err := uuid.FromString(conversation.CreatorID)
+	if err != nil {
+		return domain.Conversation{}, errors.Wrap(err, "invalid creator id")
+	}
+
+	userIds := make([]uuid.UUID, 0, len(conversation.ParticipantIDs))
+	for _, participantId := range conversation.ParticipantIDs {
+		userId, err := uuid.FromString(participantId)
+		if err != nil {
+			return domain.Conversation{}, errors.Wrap(err, "invalid participant id")
+		}
+		userIds = append(userIds, userId)
+	}
+
+	var saved models.Conversation
+	err = repo.db.Transaction(func(tx *storage.Connection) error {
+		if err := tx.RawQuery(
+			"INSERT INTO conversations (creator_id, title, type, created_at) VALUES (?, ?, ?, ?) RETURNING *",
+			creatorId, conversation.Title, conversation.Type, conversation.CreatedAt,
+		).First(&saved); err != nil {
+			return errors.Wrap(err, "failed to save conversation")
+		}
+
+		for _, userId := range userIds {
+			// the creator owns the groups they create
+			role := models.ParticipantRoleMember
+			if conversation.IsGroup() && userId == creatorId {
+				role = models.ParticipantRoleOwner
+			}
+
+			if err := tx.RawQuery(
+				"INSERT INTO participants (conversation_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+				saved.ID, userId, role, saved.CreatedAt,
+			).Exec(); err != nil {
+				return errors.Wrap(err, "failed to save participant")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return domain.Conversation{}, err
+	}
+
+	conversations := []domain.Conversation{conversationFromModel(saved)}
+	if err := repo.loadParticipants(conversations); err != nil {
+		return domain.Conversation{}, err
+	}
+
+	return conversations[0], nil
+}
+
+// FindOrCreateDirectConversation returns the single conversation between
+// conversation.CreatorID and peerId, creating it unless it exists, and reports
+// whether it was created. The unique key on the unordered pair of users keeps
+// concurrent requests from creating two. A user alone has a conversation with
+// themselves.
+func (repo *ConversationRepositoryImpl) FindOrCreateDirectConversation(conversation domain.Conversation, peerId string) (domain.Conversation, bool, error) {
+	creatorId, err := uuid.FromString(conversation.CreatorID)
+	if err != nil {
+		return domain.Conversation{}, false, errors.Wrap(err, "invalid creator id")
+	}
+
+	otherId, err := uuid.FromString(peerId)
+	if err != nil {
+		return domain.Conversation{}, false, errors.Wrap(err, "invalid participant id")
+	}
+
+	key := directKey(creatorId, otherId)
+
+	var saved models.Conversation
+	created := true
+	err = repo.db.Transaction(func(tx *storage.Connection) error {
+		// a conflicting insert waits for the other transaction and then
+		// inserts nothing
+		if err := tx.RawQuery(
+			`INSERT INTO conversations (creator_id, title, type, dm_key, created_at) VALUES (?, '', ?, ?, ?)
+			ON CONFLICT (dm_key) DO NOTHING
+			RETURNING *`,
+			creatorId, models.ConversationTypeSingle, key, conversation.CreatedAt,
+		).First(&saved); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				created = false
+				return nil
+			}
+			return errors.Wrap(err, "failed to save conversation")
+		}
+
+		userIds := []uuid.UUID{creatorId}
+		if otherId != creatorId {
+			userIds = append(userIds, otherId)
+		}
+
+		for _, userId := range userIds {
+			if err := tx.RawQuery(
+				"INSERT INTO participants (conversation_id, user_id, created_at) VALUES (?, ?, ?)",
+				saved.ID, userId, saved.CreatedAt,
+			).Exec(); err != nil {
+				return errors.Wrap(err, "failed to save participant")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return domain.Conversation{}, false, err
+	}
+
+	if !created {
+		existing, err := repo.findDirectConversation(key)
+		return existing, false, err
+	}
+
+	conversations := []domain.Conversation{conversationFromModel(saved)}
+	if err := repo.loadParticipants(conversations); err != nil {
+		return domain.Conversation{}, false, err
+	}
+
+	return conversations[0], true, nil
+}
+
+func (repo *ConversationRepositoryImpl) findDirectConversation(key string) (domain.Conversation, error) {
+	var conversation models.Conversation
+	if err := repo.db.Q().Where("dm_key = ?", key).First(&conversation); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return domain.Conversation{}, models.ConversationNotFoundError{}
+		}
+		return domain.Conversation{}, errors.Wrap(err, "failed to find conversation")
+	}
+
+	conversations := []domain.Conversation{conversationFromModel(conversation)}
+	if err := repo.loadParticipants(conversations); err != nil {
+		return domain.Conversation{}, err
+	}
+
+	return conversations[0], nil
+}
+
+func (repo *ConversationRepositoryImpl) FindConversationByID(id int64) (domain.Conversation, error) {
+	var conversation models.Conversation
+	if err := repo.db.Q().Where("id = ?", id).First(&conversation); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return domain.Conversation{}, models.ConversationNotFoundError{}
+		}
+		return domain.Conversation{}, errors.Wrap(err, "failed to find conversation")
+	}
+
+	conversations := []domain.Conversation{conversationFromModel(conversation)}
+	if err := repo.loadParticipants(conversations); err != nil {
+		return domain.Conversation{}, err
+	}
+
+	return conversations[0], nil
+}
+
+// FindConversationsOfUser returns a page of the conversations the user takes
+// part in, latest first.
+func (repo *ConversationRepositoryImpl) FindConversationsOfUser(userId uuid.UUID, offset, limit int) (domain.ListResult[domain.Conversation], error) {
+	var found []models.Conversation
+	if err := repo.db.RawQuery(
+		`SELECT c.* FROM conversations c
+		JOIN participants p ON p.conversation_id = c.id
+		WHERE p.user_id = ?
+		ORDER BY c.id DESC LIMIT ? OFFSET ?`,
+		userId, limit, offset,
+	).All(&found); err != nil {
+		return domain.ListResult[domain.Conversation]{}, errors.Wrap(err, "failed to find conversations")
+	}
+
+	count, err := repo.db.Q().Where("user_id = ?", userId).Count(&models.Participant{})
+	if err != nil {
+		return domain.ListResult[domain.Conversation]{}, errors.Wrap(err, "failed to count conversations")
+	}
+
+	conversations := make([]domain.Conversation, 0, len(found))
+	for _, model := range found {
+		conversations = append(conversations, conversationFromModel(model))
+	}
+
+	if err := repo.loadParticipants(conversations); err != nil {
+		return domain.ListResult[domain.Conversation]{}, err
+	}
+
+	return domain.ListResult[domain.Conversation]{
+		Items: conversations,
+		Count: int64(count),
+	}, nil
+}
+
+func (repo *ConversationRepositoryImpl) UpdateConversationTitle(id int64, title string) (domain.Conversation, error) {
+	var conversation models.Conversation
+	if err := repo.db.RawQuery(
+		"UPDATE conversations SET title = ?, updated_at = now() WHERE id = ? RETURNING *",
+		title, id,
+	).First(&conversation); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return domain.Conversation{}, models.ConversationNotFoundError{}
+		}
+		return domain.Conversation{}, errors.Wrap(err, "failed to update conversation")
+	}
+
+	conversations := []domain.Conversation{conversationFromModel(conversation)}
+	if err := repo.loadParticipants(conversations); err != nil {
+		return domain.Conversation{}, err
+	}
+
+	return conversations[0], nil
+}
+
+// DeleteConversation deletes the conversation, its participants and its
+// messages.
+func (repo *ConversationRepositoryImpl) DeleteConversation(id int64) error {
+	if err := repo.db.RawQuery("DELETE FROM conversations WHERE id = ?", id).Exec(); err != nil {
+		return errors.Wrap(err, "failed to delete conversation")
+	}
+
+	return nil
+}
+
+// loadParticipants fills in the participants of conversations with a single
+// query.
+func (repo *ConversationRepositoryImpl) loadParticipants(conversations []domain.Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, 0, len(conversations))
+	for _, conversation := range conversations {
+		ids = append(ids, conversation.ID)
+	}
+
+	var participants []models.Participant
+	if err := repo.db.Q().Where("conversation_id IN (?)", ids...).Order("created_at ASC, id ASC").All(&participants); err != nil {
+		return errors.Wrap(err, "failed to find participants")
+	}
+
+	byConversation := make(map[int64][]domain.Participant)
+	for _, participant := range participants {
+		byConversation[participant.ConversationID] = append(byConversation[participant.ConversationID], participantFromModel(participant))
+	}
+
+	for i := range conversations {
+		conversations[i].Participants = byConversation[conversations[i].ID]
+		conversations[i].ParticipantIDs = make([]string, 0, len(conversations[i].Participants))
+		for _, participant := range conversations[i].Participants {
+			conversations[i].ParticipantIDs = append(conversations[i].ParticipantIDs, participant.UserID)
+		}
+	}
+
+	return nil
+}
+
+// directKey identifies the single conversation between two users, whatever
+// the order they are given in.
+func directKey(a, b uuid.UUID) string {
+	if b.String() < a.String() {
+		a, b = b, a
+	}
+	return a.String() + ":" + b.String()
+}
+
+func conversationFromModel(model models.Conversation) domain.Conversation {
+	return domain.Conversation{
+		ID:        model.ID,
+		CreatorID: model.CreatorID.String(),
+		Title:     model.Title,
+		Type:      model.Type,
+		LastSeq:   model.LastSeq,
+		CreatedAt: model.CreatedAt,
+		UpdatedAt: model.UpdatedAt,
+	}
+}
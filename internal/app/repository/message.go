@@ -0,0 +1,405 @@
+package repository
+
+import (
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/app/domain/factory"
+	"github.com/tranminhquanq/gomess/internal/models"
+	"github.com/tranminhquanq/gomess/internal/storage"
+)
+
+var (
+	messageFactory = factory.MessageFactory{}
+)
+
+type MessageRepositoryImpl struct {
+	db *storage.Connection
+}
+
+func NewMessageRepository(db *storage.Connection) *MessageRepositoryImpl {
+	return &MessageRepositoryImpl{db: db}
+}
+
+// SaveMessage stores message and its attachments with the next sequence number
+// of its conversation and the next stream sequence number. It fails with a DuplicateMessageError when the sender already
+// stored a message with the same client message ID in the conversation.
+func (repo *MessageRepositoryImpl) SaveMessage(message domain.Message) (domain.Message, error) {
+	senderId, err := uuid.FromString(message.SenderID)
+	if err != nil {
+		return domain.Message{}, errors.Wrap(err, "invalid sender id")
+	}
+
+	var saved models.Message
+	var attachments []domain.Attachment
+	err = repo.db.Transaction(func(tx *storage.Connection) error {
+		// bumping the sequence locks the conversation until the message
+		// is stored, so that sequence numbers follow the storage order
+		var conversation models.Conversation
+		if err := tx.RawQuery(
+			"UPDATE conversations SET last_seq = last_seq + 1 WHERE id = ? RETURNING *",
+			message.ConversationID,
+		).First(&conversation); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				return models.ConversationNotFoundError{}
+			}
+			return errors.Wrap(err, "failed to allocate message sequence number")
+		}
+
+		// the stream sequence number is taken as late as the attachments
+		// allow: its lock serializes the commits of every message, which
+		// keeps the numbers in commit order, so it must be held briefly
+		if err := tx.RawQuery(
+			`WITH stream AS (UPDATE message_stream SET last_seq = last_seq + 1 RETURNING last_seq)
+			INSERT INTO messages (conversation_id, sender_id, client_message_id, seq, type, message, created_at, stream_seq)
+			VALUES (?, ?, ?, ?, ?, ?, ?, (SELECT last_seq FROM stream))
+			ON CONFLICT (conversation_id, sender_id, client_message_id) DO NOTHING
+			RETURNING *`,
+			message.ConversationID,
+			senderId,
+			storage.NullString(message.ClientMessageID),
+			conversation.LastSeq,
+			message.Type,
+			message.Message,
+			message.CreatedAt,
+		).First(&saved); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				// rolls back the sequence numbers taken above
+				return models.DuplicateMessageError{}
+			}
+			return errors.Wrap(err, "failed to save message")
+		}
+
+		for _, attachment := range message.Attachments {
+			var model models.Attachment
+			if err := tx.RawQuery(
+				"INSERT INTO attachments (message_id, type, url, created_at) VALUES (?, ?, ?, ?) RETURNING *",
+				saved.ID, attachment.Type, attachment.URL, saved.CreatedAt,
+			).First(&model); err != nil {
+				return errors.Wrap(err, "failed to save attachment")
+			}
+			attachments = append(attachments, attachmentFromModel(model))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return domain.Message{}, err
+	}
+
+	result := messageFromModel(saved)
+	result.Attachments = attachments
+
+	return result, nil
+}
+
+// UpdateMessage replaces the text of the message, keeping the previous one as
+// a revision.
+func (repo *MessageRepositoryImpl) UpdateMessage(id int64, message string, editedAt time.Time) (domain.Message, error) {
+	var updated models.Message
+	err := repo.db.Transaction(func(tx *storage.Connection) error {
+		var current models.Message
+		if err := tx.RawQuery("SELECT * FROM messages WHERE id = ? FOR UPDATE", id).First(&current); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				return models.MessageNotFoundError{}
+			}
+			return errors.Wrap(err, "failed to find message")
+		}
+
+		writtenAt := current.CreatedAt
+		if current.UpdatedAt != nil {
+			writtenAt = *current.UpdatedAt
+		}
+
+		if err := tx.RawQuery(
+			"INSERT INTO message_revisions (message_id, message, created_at, replaced_at) VALUES (?, ?, ?, ?)",
+			current.ID, current.Message, writtenAt, editedAt,
+		).Exec(); err != nil {
+			return errors.Wrap(err, "failed to save message revision")
+		}
+
+		if err := tx.RawQuery(
+			"UPDATE messages SET message = ?, updated_at = ? WHERE id = ? RETURNING *",
+			message, editedAt, current.ID,
+		).First(&updated); err != nil {
+			return errors.Wrap(err, "failed to update message")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return domain.Message{}, err
+	}
+
+	messages := []domain.Message{messageFromModel(updated)}
+	if err := repo.loadAttachments(messages); err != nil {
+		return domain.Message{}, err
+	}
+
+	return messages[0], nil
+}
+
+func (repo *MessageRepositoryImpl) FindMessageByID(id int64) (domain.Message, error) {
+	var message models.Message
+	if err := repo.db.Q().Where("id = ?", id).First(&message); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return domain.Message{}, models.MessageNotFoundError{}
+		}
+		return domain.Message{}, errors.Wrap(err, "failed to find message")
+	}
+
+	messages := []domain.Message{messageFromModel(message)}
+	if err := repo.loadAttachments(messages); err != nil {
+		return domain.Message{}, err
+	}
+
+	return messages[0], nil
+}
+
+// FindMessageRevisions returns the previous texts of the message, oldest
+// first.
+func (repo *MessageRepositoryImpl) FindMessageRevisions(messageId int64) ([]domain.MessageRevision, error) {
+	var revisions []models.MessageRevision
+	if err := repo.db.Q().Where("message_id = ?", messageId).Order("id ASC").All(&revisions); err != nil {
+		return nil, errors.Wrap(err, "failed to find message revisions")
+	}
+
+	result := make([]domain.MessageRevision, 0, len(revisions))
+	for _, revision := range revisions {
+		result = append(result, domain.MessageRevision{
+			ID:         revision.ID,
+			Message:    revision.Message,
+			CreatedAt:  revision.CreatedAt,
+			ReplacedAt: revision.ReplacedAt,
+		})
+	}
+
+	return result, nil
+}
+
+// FindMessageByClientMessageID returns the message the sender stored in the
+// conversation under clientMessageId.
+func (repo *MessageRepositoryImpl) FindMessageByClientMessageID(conversationId int64, senderId uuid.UUID, clientMessageId string) (domain.Message, error) {
+	var message models.Message
+	if err := repo.db.Q().Where("conversation_id = ? AND sender_id = ? AND client_message_id = ?", conversationId, senderId, clientMessageId).First(&message); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return domain.Message{}, models.MessageNotFoundError{}
+		}
+		return domain.Message{}, errors.Wrap(err, "failed to find message")
+	}
+
+	messages := []domain.Message{messageFromModel(message)}
+	if err := repo.loadAttachments(messages); err != nil {
+		return domain.Message{}, err
+	}
+
+	return messages[0], nil
+}
+
+// FindMessagesInConversation returns at most cursor.Limit messages of the
+// conversation on the side of the cursor, latest first, with their
+// attachments. Pages are found by sequence number, so that messages sent in
+// the meantime do not shift them.
+func (repo *MessageRepositoryImpl) FindMessagesInConversation(conversationId int64, cursor domain.MessageCursor) (domain.MessagePage, error) {
+	// one more message than asked tells whether there are more past the page
+	var messages []models.Message
+	var err error
+	switch {
+	case cursor.After > 0:
+		err = repo.db.RawQuery(
+			"SELECT * FROM messages WHERE conversation_id = ? AND seq > ? ORDER BY seq ASC LIMIT ?",
+			conversationId, cursor.After, cursor.Limit+1,
+		).All(&messages)
+	case cursor.Before > 0:
+		err = repo.db.RawQuery(
+			"SELECT * FROM messages WHERE conversation_id = ? AND seq < ? ORDER BY seq DESC LIMIT ?",
+			conversationId, cursor.Before, cursor.Limit+1,
+		).All(&messages)
+	default:
+		err = repo.db.RawQuery(
+			"SELECT * FROM messages WHERE conversation_id = ? ORDER BY seq DESC LIMIT ?",
+			conversationId, cursor.Limit+1,
+		).All(&messages)
+	}
+	if err != nil {
+		return domain.MessagePage{}, errors.Wrap(err, "failed to find messages")
+	}
+
+	more := len(messages) > cursor.Limit
+	if more {
+		messages = messages[:cursor.Limit]
+	}
+
+	page := domain.MessagePage{Items: messagesFromModels(messages)}
+	switch {
+	case cursor.After > 0:
+		slices.Reverse(page.Items)
+		page.HasNewer = more
+		page.HasOlder, err = repo.hasMessages(conversationId, "seq <= ?", cursor.After)
+	case cursor.Before > 0:
+		page.HasOlder = more
+		page.HasNewer, err = repo.hasMessages(conversationId, "seq >= ?", cursor.Before)
+	default:
+		page.HasOlder = more
+	}
+	if err != nil {
+		return domain.MessagePage{}, err
+	}
+
+	if err := repo.loadAttachments(page.Items); err != nil {
+		return domain.MessagePage{}, err
+	}
+
+	return page, nil
+}
+
+// hasMessages reports whether the conversation has messages matching the
+// condition on the side of a cursor.
+func (repo *MessageRepositoryImpl) hasMessages(conversationId int64, condition string, seq int64) (bool, error) {
+	exists, err := repo.db.Q().Where("conversation_id = ? AND "+condition, conversationId, seq).Exists(&models.Message{})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check messages")
+	}
+
+	return exists, nil
+}
+
+// FindMessagesAfterSeq returns at most limit messages of the conversation
+// that follow seq, oldest first.
+func (repo *MessageRepositoryImpl) FindMessagesAfterSeq(conversationId int64, seq int64, limit int) ([]domain.Message, error) {
+	var messages []models.Message
+	if err := repo.db.RawQuery(
+		"SELECT * FROM messages WHERE conversation_id = ? AND seq > ? ORDER BY seq ASC LIMIT ?",
+		conversationId, seq, limit,
+	).All(&messages); err != nil {
+		return nil, errors.Wrap(err, "failed to find messages")
+	}
+
+	result := messagesFromModels(messages)
+	if err := repo.loadAttachments(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// FindUserMessagesAfterStreamSeq returns at most limit messages following
+// the stream sequence number across every conversation the user takes part
+// in, in commit order.
+func (repo *MessageRepositoryImpl) FindUserMessagesAfterStreamSeq(userId uuid.UUID, streamSeq int64, limit int) ([]domain.Message, error) {
+	var messages []models.Message
+	if err := repo.db.RawQuery(
+		`SELECT m.* FROM messages m
+		JOIN participants p ON p.conversation_id = m.conversation_id
+		WHERE p.user_id = ? AND m.stream_seq > ?
+		ORDER BY m.stream_seq ASC LIMIT ?`,
+		userId, streamSeq, limit,
+	).All(&messages); err != nil {
+		return nil, errors.Wrap(err, "failed to find messages")
+	}
+
+	result := messagesFromModels(messages)
+	if err := repo.loadAttachments(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (repo *MessageRepositoryImpl) FindMessageBySeq(conversationId int64, seq int64) (domain.Message, error) {
+	var message models.Message
+	if err := repo.db.Q().Where("conversation_id = ? AND seq = ?", conversationId, seq).First(&message); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return domain.Message{}, models.MessageNotFoundError{}
+		}
+		return domain.Message{}, errors.Wrap(err, "failed to find message")
+	}
+
+	return messageFromModel(message), nil
+}
+
+// FindSenderIDs returns the distinct senders of the messages of the
+// conversation with a sequence number in (afterSeq, uptoSeq], system messages
+// aside.
+func (repo *MessageRepositoryImpl) FindSenderIDs(conversationId int64, afterSeq, uptoSeq int64) ([]uuid.UUID, error) {
+	var messages []models.Message
+	if err := repo.db.RawQuery(
+		"SELECT DISTINCT sender_id FROM messages WHERE conversation_id = ? AND seq > ? AND seq <= ? AND type <> ?",
+		conversationId, afterSeq, uptoSeq, models.MessageTypeSystem,
+	).All(&messages); err != nil {
+		return nil, errors.Wrap(err, "failed to find message senders")
+	}
+
+	ids := make([]uuid.UUID, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.SenderID)
+	}
+
+	return ids, nil
+}
+
+// loadAttachments fills in the attachments of messages with a single query.
+func (repo *MessageRepositoryImpl) loadAttachments(messages []domain.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+
+	var attachments []models.Attachment
+	if err := repo.db.Q().Where("message_id IN (?)", ids...).Order("id ASC").All(&attachments); err != nil {
+		return errors.Wrap(err, "failed to find attachments")
+	}
+
+	byMessage := make(map[int64][]domain.Attachment)
+	for _, attachment := range attachments {
+		byMessage[attachment.MessageID] = append(byMessage[attachment.MessageID], attachmentFromModel(attachment))
+	}
+
+	for i := range messages {
+		messages[i].Attachments = byMessage[messages[i].ID]
+	}
+
+	return nil
+}
+
+func messagesFromModels(models []models.Message) []domain.Message {
+	messages := make([]domain.Message, 0, len(models))
+	for _, model := range models {
+		messages = append(messages, messageFromModel(model))
+	}
+	return messages
+}
+
+func messageFromModel(model models.Message) domain.Message {
+	message := messageFactory.CreateMessage(
+		model.ID,
+		model.ConversationID,
+		model.SenderID.String(),
+		model.Message,
+	)
+	message.ClientMessageID = model.ClientMessageID.String()
+	message.Seq = model.Seq
+	message.StreamSeq = model.StreamSeq
+	message.Type = model.Type
+	message.CreatedAt = model.CreatedAt
+	message.UpdatedAt = model.UpdatedAt
+
+	return message
+}
+
+func attachmentFromModel(model models.Attachment) domain.Attachment {
+	return domain.Attachment{
+		ID:        model.ID,
+		Type:      model.Type,
+		URL:       model.URL,
+		CreatedAt: model.CreatedAt,
+	}
+}
@@ -0,0 +1,377 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/models"
+	"github.com/tranminhquanq/gomess/internal/storage"
+)
+
+type ParticipantRepositoryImpl struct {
+	db *storage.Connection
+}
+
+func NewParticipantRepository(db *storage.Connection) *ParticipantRepositoryImpl {
+	return &ParticipantRepositoryImpl{db: db}
+}
+
+func (repo *ParticipantRepositoryImpl) IsParticipant(conversationId int64, userId uuid.UUID) (bool, error) {
+	exists, err := repo.db.Q().Where("conversation_id = ? AND user_id = ?", conversationId, userId).Exists(&models.Participant{})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check conversation participant")
+	}
+
+	return exists, nil
+}
+
+// FindConversationIDs returns the conversations the user takes part in.
+func (repo *ParticipantRepositoryImpl) FindConversationIDs(userId uuid.UUID) ([]int64, error) {
+	var participants []models.Participant
+	if err := repo.db.Q().Where("user_id = ?", userId).Order("conversation_id ASC").All(&participants); err != nil {
+		return nil, errors.Wrap(err, "failed to find conversations of participant")
+	}
+
+	ids := make([]int64, 0, len(participants))
+	for _, participant := range participants {
+		ids = append(ids, participant.ConversationID)
+	}
+
+	return ids, nil
+}
+
+// FindPeerIDs returns the users sharing at least one conversation with the
+// user.
+func (repo *ParticipantRepositoryImpl) FindPeerIDs(userId uuid.UUID) ([]uuid.UUID, error) {
+	var participants []models.Participant
+	if err := repo.db.RawQuery(
+		`SELECT DISTINCT peer.user_id FROM participants p
+		JOIN participants peer ON peer.conversation_id = p.conversation_id
+		WHERE p.user_id = ? AND peer.user_id <> p.user_id`,
+		userId,
+	).All(&participants); err != nil {
+		return nil, errors.Wrap(err, "failed to find conversation peers")
+	}
+
+	ids := make([]uuid.UUID, 0, len(participants))
+	for _, participant := range participants {
+		ids = append(ids, participant.UserID)
+	}
+
+	return ids, nil
+}
+
+// FindParticipantIDs returns the users taking part in the conversation.
+func (repo *ParticipantRepositoryImpl) FindParticipantIDs(conversationId int64) ([]uuid.UUID, error) {
+	var participants []models.Participant
+	if err := repo.db.Q().Where("conversation_id = ?", conversationId).Order("created_at ASC").All(&participants); err != nil {
+		return nil, errors.Wrap(err, "failed to find participants")
+	}
+
+	if len(participants) == 0 {
+		// a conversation is deleted with its last participant, unless
+		// it is not found at all
+		exists, err := repo.db.Q().Where("id = ?", conversationId).Exists(&models.Conversation{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find conversation")
+		}
+		if !exists {
+			return nil, models.ConversationNotFoundError{}
+		}
+	}
+
+	ids := make([]uuid.UUID, 0, len(participants))
+	for _, participant := range participants {
+		ids = append(ids, participant.UserID)
+	}
+
+	return ids, nil
+}
+
+// FindReceipts returns the receipt of every participant of the conversation.
+func (repo *ParticipantRepositoryImpl) FindReceipts(conversationId int64) ([]domain.Receipt, error) {
+	var participants []models.Participant
+	if err := repo.db.Q().Where("conversation_id = ?", conversationId).All(&participants); err != nil {
+		return nil, errors.Wrap(err, "failed to find participants")
+	}
+
+	receipts := make([]domain.Receipt, 0, len(participants))
+	for _, participant := range participants {
+		receipts = append(receipts, receiptFromModel(participant))
+	}
+
+	return receipts, nil
+}
+
+// UpdateReceipt moves the delivery and read watermarks of the user in the
+// conversation up to deliveredSeq and readSeq, and returns the receipt before
+// and after. Watermarks never move backwards nor past the latest message of
+// the conversation, and what is read counts as delivered.
+func (repo *ParticipantRepositoryImpl) UpdateReceipt(conversationId int64, userId uuid.UUID, deliveredSeq, readSeq int64) (previous, current domain.Receipt, err error) {
+	err = repo.db.Transaction(func(tx *storage.Connection) error {
+		var participant models.Participant
+		if err := tx.RawQuery(
+			"SELECT * FROM participants WHERE conversation_id = ? AND user_id = ? FOR UPDATE",
+			conversationId, userId,
+		).First(&participant); err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				return models.ParticipantNotFoundError{}
+			}
+			return errors.Wrap(err, "failed to find participant")
+		}
+		previous = receiptFromModel(participant)
+
+		if err := tx.RawQuery(
+			`UPDATE participants p SET
+				read_seq = GREATEST(p.read_seq, LEAST(?, c.last_seq)),
+				delivered_seq = GREATEST(p.delivered_seq, LEAST(GREATEST(?, ?), c.last_seq))
+			FROM conversations c
+			WHERE c.id = p.conversation_id AND p.id = ?
+			RETURNING p.*`,
+			readSeq, deliveredSeq, readSeq, participant.ID,
+		).First(&participant); err != nil {
+			return errors.Wrap(err, "failed to update participant receipt")
+		}
+		current = receiptFromModel(participant)
+
+		return nil
+	})
+
+	return previous, current, err
+}
+
+// AddParticipants adds the users to the conversation with the given role and
+// returns those who did not take part in it already.
+func (repo *ParticipantRepositoryImpl) AddParticipants(conversationId int64, userIds []uuid.UUID, role models.ParticipantRole) ([]uuid.UUID, error) {
+	added := make([]uuid.UUID, 0, len(userIds))
+	err := repo.db.Transaction(func(tx *storage.Connection) error {
+		for _, userId := range userIds {
+			var participants []models.Participant
+			if err := tx.RawQuery(
+				`INSERT INTO participants (conversation_id, user_id, role, created_at) VALUES (?, ?, ?, now())
+				ON CONFLICT (conversation_id, user_id) DO NOTHING
+				RETURNING *`,
+				conversationId, userId, role,
+			).All(&participants); err != nil {
+				return errors.Wrap(err, "failed to add participant")
+			}
+
+			if len(participants) > 0 {
+				added = append(added, userId)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return added, nil
+}
+
+// RemoveParticipant removes the user from the conversation, which the actor
+// may do when it can manage members and outranks the user.
+func (repo *ParticipantRepositoryImpl) RemoveParticipant(conversationId int64, actorId, userId uuid.UUID) error {
+	return repo.db.Transaction(func(tx *storage.Connection) error {
+		roles, err := lockParticipantRoles(tx, conversationId, actorId, userId)
+		if err != nil {
+			return err
+		}
+
+		actorRole, targetRole := roles[actorId], roles[userId]
+		if !domain.CanManageMembers(actorRole) || !domain.Outranks(actorRole, targetRole) {
+			return models.PermissionDeniedError{Reason: fmt.Sprintf("A participant with role %s cannot remove one with role %s", actorRole, targetRole)}
+		}
+
+		if err := tx.RawQuery(
+			"DELETE FROM participants WHERE conversation_id = ? AND user_id = ?",
+			conversationId, userId,
+		).Exec(); err != nil {
+			return errors.Wrap(err, "failed to remove participant")
+		}
+
+		return nil
+	})
+}
+
+// UpdateParticipantRole gives the user the role in the conversation, which
+// owners may do for anyone but owners, and returns whether the role changed.
+func (repo *ParticipantRepositoryImpl) UpdateParticipantRole(conversationId int64, actorId, userId uuid.UUID, role models.ParticipantRole) (bool, error) {
+	changed := false
+	err := repo.db.Transaction(func(tx *storage.Connection) error {
+		roles, err := lockParticipantRoles(tx, conversationId, actorId, userId)
+		if err != nil {
+			return err
+		}
+
+		if roles[actorId] != models.ParticipantRoleOwner {
+			return models.PermissionDeniedError{Reason: "Only owners can change the role of participants"}
+		}
+
+		if roles[userId] == models.ParticipantRoleOwner {
+			return models.PermissionDeniedError{Reason: "Owners keep their role until they transfer the ownership"}
+		}
+
+		if roles[userId] == role {
+			return nil
+		}
+
+		if err := tx.RawQuery(
+			"UPDATE participants SET role = ? WHERE conversation_id = ? AND user_id = ?",
+			role, conversationId, userId,
+		).Exec(); err != nil {
+			return errors.Wrap(err, "failed to update participant role")
+		}
+		changed = true
+
+		return nil
+	})
+
+	return changed, err
+}
+
+// TransferOwnership makes toId an owner of the conversation in place of
+// fromId, who stays on as an admin.
+func (repo *ParticipantRepositoryImpl) TransferOwnership(conversationId int64, fromId, toId uuid.UUID) error {
+	return repo.db.Transaction(func(tx *storage.Connection) error {
+		roles, err := lockParticipantRoles(tx, conversationId, fromId, toId)
+		if err != nil {
+			return err
+		}
+
+		if roles[fromId] != models.ParticipantRoleOwner {
+			return models.PermissionDeniedError{Reason: "Only owners can transfer the ownership"}
+		}
+
+		for _, change := range []struct {
+			userId uuid.UUID
+			role   models.ParticipantRole
+		}{
+			{toId, models.ParticipantRoleOwner},
+			{fromId, models.ParticipantRoleAdmin},
+		} {
+			if err := tx.RawQuery(
+				"UPDATE participants SET role = ? WHERE conversation_id = ? AND user_id = ?",
+				change.role, conversationId, change.userId,
+			).Exec(); err != nil {
+				return errors.Wrap(err, "failed to transfer ownership")
+			}
+		}
+
+		return nil
+	})
+}
+
+// lockParticipantRoles locks the participant rows of the actor and the user
+// it acts on, in the order LeaveConversation locks them, and returns their
+// roles. The actor must take part in the conversation, and so must the user.
+func lockParticipantRoles(tx *storage.Connection, conversationId int64, actorId, userId uuid.UUID) (map[uuid.UUID]models.ParticipantRole, error) {
+	var participants []models.Participant
+	if err := tx.RawQuery(
+		"SELECT * FROM participants WHERE conversation_id = ? AND user_id IN (?, ?) ORDER BY created_at ASC, id ASC FOR UPDATE",
+		conversationId, actorId, userId,
+	).All(&participants); err != nil {
+		return nil, errors.Wrap(err, "failed to find participants")
+	}
+
+	roles := make(map[uuid.UUID]models.ParticipantRole, len(participants))
+	for _, participant := range participants {
+		roles[participant.UserID] = participant.Role
+	}
+
+	if _, ok := roles[actorId]; !ok {
+		return nil, models.ConversationNotFoundError{}
+	}
+	if _, ok := roles[userId]; !ok {
+		return nil, models.ParticipantNotFoundError{}
+	}
+
+	return roles, nil
+}
+
+// LeaveConversation removes the user from the conversation. When the last
+// owner leaves, the oldest admin becomes owner, or the oldest member when
+// there is no admin, and is returned. The conversation is deleted once nobody
+// takes part in it anymore.
+func (repo *ParticipantRepositoryImpl) LeaveConversation(conversationId int64, userId uuid.UUID) (newOwnerId uuid.UUID, deleted bool, err error) {
+	err = repo.db.Transaction(func(tx *storage.Connection) error {
+		// locking every participant keeps concurrent departures from
+		// leaving the group without an owner
+		var participants []models.Participant
+		if err := tx.RawQuery(
+			"SELECT * FROM participants WHERE conversation_id = ? ORDER BY created_at ASC, id ASC FOR UPDATE",
+			conversationId,
+		).All(&participants); err != nil {
+			return errors.Wrap(err, "failed to find participants")
+		}
+
+		var leaving *models.Participant
+		var remaining []models.Participant
+		for i := range participants {
+			if participants[i].UserID == userId {
+				leaving = &participants[i]
+			} else {
+				remaining = append(remaining, participants[i])
+			}
+		}
+		if leaving == nil {
+			return models.ParticipantNotFoundError{}
+		}
+
+		if err := tx.RawQuery("DELETE FROM participants WHERE id = ?", leaving.ID).Exec(); err != nil {
+			return errors.Wrap(err, "failed to remove participant")
+		}
+
+		if len(remaining) == 0 {
+			if err := tx.RawQuery("DELETE FROM conversations WHERE id = ?", conversationId).Exec(); err != nil {
+				return errors.Wrap(err, "failed to delete conversation")
+			}
+			deleted = true
+			return nil
+		}
+
+		if leaving.Role != models.ParticipantRoleOwner {
+			return nil
+		}
+
+		successor := remaining[0]
+		for _, participant := range remaining {
+			if participant.Role == models.ParticipantRoleOwner {
+				return nil
+			}
+			if participant.Role == models.ParticipantRoleAdmin && successor.Role != models.ParticipantRoleAdmin {
+				successor = participant
+			}
+		}
+
+		if err := tx.RawQuery("UPDATE participants SET role = ? WHERE id = ?", models.ParticipantRoleOwner, successor.ID).Exec(); err != nil {
+			return errors.Wrap(err, "failed to promote participant")
+		}
+		newOwnerId = successor.UserID
+
+		return nil
+	})
+
+	return newOwnerId, deleted, err
+}
+
+func participantFromModel(participant models.Participant) domain.Participant {
+	return domain.Participant{
+		UserID:   participant.UserID.String(),
+		Role:     participant.Role,
+		JoinedAt: participant.CreatedAt,
+	}
+}
+
+func receiptFromModel(participant models.Participant) domain.Receipt {
+	return domain.Receipt{
+		ConversationID: participant.ConversationID,
+		UserID:         participant.UserID.String(),
+		DeliveredSeq:   participant.DeliveredSeq,
+		ReadSeq:        participant.ReadSeq,
+	}
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/models"
+	"github.com/tranminhquanq/gomess/internal/storage"
+)
+
+type PresenceRepositoryImpl struct {
+	db *storage.Connection
+}
+
+func NewPresenceRepository(db *storage.Connection) *PresenceRepositoryImpl {
+	return &PresenceRepositoryImpl{db: db}
+}
+
+// FindPresences returns the stored presence of the given users. Users that
+// never chose a status nor connected have none.
+func (repo *PresenceRepositoryImpl) FindPresences(userIds []uuid.UUID) ([]domain.Presence, error) {
+	if len(userIds) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, 0, len(userIds))
+	for _, userId := range userIds {
+		args = append(args, userId)
+	}
+
+	var presences []models.Presence
+	if err := repo.db.Q().Where("user_id IN (?)", args...).All(&presences); err != nil {
+		return nil, errors.Wrap(err, "failed to find presences")
+	}
+
+	result := make([]domain.Presence, 0, len(presences))
+	for _, presence := range presences {
+		result = append(result, presenceFromModel(presence))
+	}
+
+	return result, nil
+}
+
+func (repo *PresenceRepositoryImpl) SaveStatus(userId uuid.UUID, status domain.PresenceStatus) (domain.Presence, error) {
+	var presence models.Presence
+	if err := repo.db.RawQuery(
+		`INSERT INTO presences (user_id, status, updated_at) VALUES (?, ?, now())
+		ON CONFLICT (user_id) DO UPDATE SET status = EXCLUDED.status, updated_at = EXCLUDED.updated_at
+		RETURNING *`,
+		userId, status,
+	).First(&presence); err != nil {
+		return domain.Presence{}, errors.Wrap(err, "failed to save presence status")
+	}
+
+	return presenceFromModel(presence), nil
+}
+
+func (repo *PresenceRepositoryImpl) UpdateLastSeen(userId uuid.UUID, lastSeenAt time.Time) error {
+	if err := repo.db.RawQuery(
+		`INSERT INTO presences (user_id, last_seen_at, updated_at) VALUES (?, ?, now())
+		ON CONFLICT (user_id) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at, updated_at = EXCLUDED.updated_at`,
+		userId, lastSeenAt,
+	).Exec(); err != nil {
+		return errors.Wrap(err, "failed to update last seen")
+	}
+
+	return nil
+}
+
+func presenceFromModel(presence models.Presence) domain.Presence {
+	return domain.Presence{
+		UserID:     presence.UserID.String(),
+		Status:     domain.PresenceStatus(presence.Status),
+		LastSeenAt: presence.LastSeenAt,
+	}
+}
@@ -1,16 +1,289 @@
 package usecase
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/app/domain/repository"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
 type ChatUsecase struct {
+	participantRepository repository.ParticipantRepository
+	messageRepository     repository.MessageRepository
+}
+
+func NewChatUsecase(
+	participantRepository repository.ParticipantRepository,
+	messageRepository repository.MessageRepository,
+) *ChatUsecase {
+	return &ChatUsecase{
+		participantRepository: participantRepository,
+		messageRepository:     messageRepository,
+	}
+}
+
+// SendMessage stores message and reports whether it is a retry of a message
+// the sender already stored in the conversation under the same client message
+// ID, in which case the stored message is returned.
+func (u *ChatUsecase) SendMessage(message domain.Message) (domain.Message, bool, error) {
+	if message.ClientMessageID != "" {
+		existing, err := u.findSentMessage(message.ConversationID, message.SenderID, message.ClientMessageID)
+		if err == nil {
+			return existing, true, nil
+		}
+		if !models.IsNotFoundError(err) {
+			return domain.Message{}, false, err
+		}
+	}
+
+	saved, err := u.messageRepository.SaveMessage(message)
+	if models.IsDuplicateMessageError(err) {
+		// a concurrent retry stored it first
+		existing, err := u.findSentMessage(message.ConversationID, message.SenderID, message.ClientMessageID)
+		return existing, true, err
+	}
+	if err != nil {
+		return domain.Message{}, false, err
+	}
+
+	return saved, false, nil
 }
 
-func NewChatUsecase() *ChatUsecase {
-	return &ChatUsecase{}
+// EditMessage replaces the text of a message of the conversation, which only
+// its sender may do and, unless window is zero, only for window after sending
+// it. The previous text is kept as a revision.
+func (u *ChatUsecase) EditMessage(conversationId int64, messageId int64, userId string, text string, window time.Duration) (domain.Message, error) {
+	message, err := u.conversationMessage(conversationId, messageId)
+	if err != nil {
+		return domain.Message{}, err
+	}
+
+	if message.SenderID != userId || message.Type == models.MessageTypeSystem {
+		return domain.Message{}, models.PermissionDeniedError{Reason: "Only the sender can edit the message"}
+	}
+
+	now := time.Now()
+	if window > 0 && now.Sub(message.CreatedAt) > window {
+		return domain.Message{}, models.PermissionDeniedError{Reason: fmt.Sprintf("Messages can only be edited for %s after they are sent", window)}
+	}
+
+	if message.Message == text {
+		return message, nil
+	}
+
+	return u.messageRepository.UpdateMessage(messageId, text, now)
 }
 
-func (u *ChatUsecase) GetChatHistory() (interface{}, error) {
-	return nil, nil
+// MessageRevisions returns a message of the conversation along with its
+// previous texts, oldest first.
+func (u *ChatUsecase) MessageRevisions(conversationId int64, messageId int64) (domain.Message, []domain.MessageRevision, error) {
+	message, err := u.conversationMessage(conversationId, messageId)
+	if err != nil {
+		return domain.Message{}, nil, err
+	}
+
+	revisions, err := u.messageRepository.FindMessageRevisions(messageId)
+	if err != nil {
+		return domain.Message{}, nil, err
+	}
+
+	return message, revisions, nil
 }
 
-func (u *ChatUsecase) SendMessage() (interface{}, error) {
-	return nil, nil
+// conversationMessage returns the message, provided it belongs to the
+// conversation.
+func (u *ChatUsecase) conversationMessage(conversationId int64, messageId int64) (domain.Message, error) {
+	message, err := u.messageRepository.FindMessageByID(messageId)
+	if err != nil {
+		return domain.Message{}, err
+	}
+
+	if message.ConversationID != conversationId {
+		return domain.Message{}, models.MessageNotFoundError{}
+	}
+
+	return message, nil
+}
+
+func (u *ChatUsecase) findSentMessage(conversationId int64, senderId string, clientMessageId string) (domain.Message, error) {
+	id, err := uuid.FromString(senderId)
+	if err != nil {
+		return domain.Message{}, errors.Wrap(err, "invalid sender id")
+	}
+
+	return u.messageRepository.FindMessageByClientMessageID(conversationId, id, clientMessageId)
+}
+
+// IsParticipant reports whether the user takes part in the conversation.
+func (u *ChatUsecase) IsParticipant(conversationId int64, userId string) (bool, error) {
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return false, nil
+	}
+
+	return u.participantRepository.IsParticipant(conversationId, id)
+}
+
+// AckDelivery records that the user received the messages of the conversation
+// up to seq. It returns the receipt of the user and the senders to notify.
+func (u *ChatUsecase) AckDelivery(conversationId int64, userId string, seq int64) (domain.Receipt, []string, error) {
+	return u.updateReceipt(conversationId, userId, seq, 0)
+}
+
+// MarkRead records that the user read the messages of the conversation up to
+// seq, which counts as received as well. It returns the receipt of the user
+// and the senders to notify.
+func (u *ChatUsecase) MarkRead(conversationId int64, userId string, seq int64) (domain.Receipt, []string, error) {
+	return u.updateReceipt(conversationId, userId, seq, seq)
+}
+
+// updateReceipt moves the watermarks of the user and returns the senders of
+// the messages whose status changed, the user excepted.
+func (u *ChatUsecase) updateReceipt(conversationId int64, userId string, deliveredSeq, readSeq int64) (domain.Receipt, []string, error) {
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return domain.Receipt{}, nil, models.ParticipantNotFoundError{}
+	}
+
+	previous, current, err := u.participantRepository.UpdateReceipt(conversationId, id, deliveredSeq, readSeq)
+	if err != nil {
+		return domain.Receipt{}, nil, err
+	}
+
+	// read messages are delivered already, so the changes span from the
+	// read watermark when it moved, from the delivery one otherwise
+	from := previous.DeliveredSeq
+	if current.ReadSeq != previous.ReadSeq {
+		from = previous.ReadSeq
+	}
+	if from >= current.DeliveredSeq {
+		return current, nil, nil
+	}
+
+	senderIds, err := u.messageRepository.FindSenderIDs(conversationId, from, current.DeliveredSeq)
+	if err != nil {
+		return domain.Receipt{}, nil, err
+	}
+
+	senders := make([]string, 0, len(senderIds))
+	for _, senderId := range senderIds {
+		if senderId != id {
+			senders = append(senders, senderId.String())
+		}
+	}
+
+	return current, senders, nil
+}
+
+// Receipts returns the receipt of every participant of the conversation.
+func (u *ChatUsecase) Receipts(conversationId int64) ([]domain.Receipt, error) {
+	return u.participantRepository.FindReceipts(conversationId)
+}
+
+// MessageReceipts aggregates the receipts of the recipients of the message of
+// the conversation with sequence number seq.
+func (u *ChatUsecase) MessageReceipts(conversationId int64, seq int64) (domain.MessageReceipts, error) {
+	message, err := u.messageRepository.FindMessageBySeq(conversationId, seq)
+	if err != nil {
+		return domain.MessageReceipts{}, err
+	}
+
+	receipts, err := u.participantRepository.FindReceipts(conversationId)
+	if err != nil {
+		return domain.MessageReceipts{}, err
+	}
+
+	return domain.AggregateReceipts(message, receipts), nil
+}
+
+// GetChatHistory returns the page of the messages of the conversation on the
+// side of the cursor, latest first, each with its status for the user.
+func (u *ChatUsecase) GetChatHistory(conversationId int64, userId string, cursor domain.MessageCursor) (domain.MessagePage, error) {
+	page, err := u.messageRepository.FindMessagesInConversation(conversationId, cursor)
+	if err != nil {
+		return domain.MessagePage{}, err
+	}
+
+	receipts, err := u.participantRepository.FindReceipts(conversationId)
+	if err != nil {
+		return domain.MessagePage{}, err
+	}
+
+	for i, message := range page.Items {
+		page.Items[i].Status = domain.StatusFor(message, userId, receipts)
+		if message.SenderID == userId {
+			aggregate := domain.AggregateReceipts(message, receipts)
+			page.Items[i].Receipts = &aggregate
+		}
+	}
+
+	return page, nil
+}
+
+// MissedMessages returns at most limit messages of the conversation that
+// follow seq, oldest first.
+func (u *ChatUsecase) MissedMessages(conversationId int64, seq int64, limit int) ([]domain.Message, error) {
+	return u.messageRepository.FindMessagesAfterSeq(conversationId, seq, limit)
+}
+
+// MissedUserMessages returns at most limit messages following the stream
+// sequence number cursor across every conversation of the user, in the order
+// they were committed.
+func (u *ChatUsecase) MissedUserMessages(userId string, cursor int64, limit int) ([]domain.Message, error) {
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid user id")
+	}
+
+	return u.messageRepository.FindUserMessagesAfterStreamSeq(id, cursor, limit)
+}
+
+// ConversationIDs returns the conversations the user takes part in.
+func (u *ChatUsecase) ConversationIDs(userId string) ([]int64, error) {
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid user id")
+	}
+
+	return u.participantRepository.FindConversationIDs(id)
+}
+
+// PeerIDs returns the users sharing at least one conversation with the user.
+func (u *ChatUsecase) PeerIDs(userId string) ([]string, error) {
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid user id")
+	}
+
+	peerIds, err := u.participantRepository.FindPeerIDs(id)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]string, 0, len(peerIds))
+	for _, peerId := range peerIds {
+		peers = append(peers, peerId.String())
+	}
+
+	return peers, nil
+}
+
+// ParticipantIDs returns the users taking part in the conversation, or a
+// ConversationNotFoundError when it does not exist.
+func (u *ChatUsecase) ParticipantIDs(conversationId int64) ([]string, error) {
+	userIds, err := u.participantRepository.FindParticipantIDs(conversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	participants := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		participants = append(participants, userId.String())
+	}
+
+	return participants, nil
 }
@@ -0,0 +1,194 @@
+package usecase
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/app/domain/repository"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+type sentKey struct {
+	conversationId  int64
+	senderId        string
+	clientMessageId string
+}
+
+// fakeMessageRepository stores messages in memory, deduplicating them on the
+// same key as the unique index of the messages table.
+type fakeMessageRepository struct {
+	repository.MessageRepository
+
+	mu       sync.Mutex
+	messages map[sentKey]domain.Message
+	nextId   int64
+	saves    int
+
+	// beforeSave is called by SaveMessage before it stores the message,
+	// e.g. to let a concurrent retry store it first.
+	beforeSave func()
+}
+
+func newFakeMessageRepository() *fakeMessageRepository {
+	return &fakeMessageRepository{messages: make(map[sentKey]domain.Message)}
+}
+
+func (r *fakeMessageRepository) SaveMessage(message domain.Message) (domain.Message, error) {
+	if r.beforeSave != nil {
+		beforeSave := r.beforeSave
+		r.beforeSave = nil
+		beforeSave()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.saves++
+	key := sentKey{message.ConversationID, message.SenderID, message.ClientMessageID}
+	if _, ok := r.messages[key]; ok && message.ClientMessageID != "" {
+		return domain.Message{}, models.DuplicateMessageError{}
+	}
+
+	r.nextId++
+	message.ID = r.nextId
+	r.messages[key] = message
+
+	return message, nil
+}
+
+func (r *fakeMessageRepository) FindMessageByClientMessageID(conversationId int64, senderId uuid.UUID, clientMessageId string) (domain.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, ok := r.messages[sentKey{conversationId, senderId.String(), clientMessageId}]
+	if !ok {
+		return domain.Message{}, models.MessageNotFoundError{}
+	}
+
+	return message, nil
+}
+
+func newTestMessage(conversationId int64, senderId string, clientMessageId string, text string) domain.Message {
+	return domain.Message{
+		ConversationID:  conversationId,
+		SenderID:        senderId,
+		ClientMessageID: clientMessageId,
+		Type:            models.MessageTypeText,
+		Message:         text,
+		CreatedAt:       time.Now(),
+	}
+}
+
+func TestSendMessageStoresNewMessage(t *testing.T) {
+	repo := newFakeMessageRepository()
+	chat := NewChatUsecase(nil, repo)
+	senderId := uuid.Must(uuid.NewV4()).String()
+
+	saved, duplicate, err := chat.SendMessage(newTestMessage(1, senderId, "m-1", "hello"))
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if duplicate {
+		t.Error("SendMessage() reported a first send as a duplicate")
+	}
+	if saved.ID == 0 {
+		t.Error("SendMessage() returned a message without an ID")
+	}
+}
+
+func TestSendMessageReturnsRetriedMessage(t *testing.T) {
+	repo := newFakeMessageRepository()
+	chat := NewChatUsecase(nil, repo)
+	senderId := uuid.Must(uuid.NewV4()).String()
+
+	first, _, err := chat.SendMessage(newTestMessage(1, senderId, "m-1", "hello"))
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+
+	retried, duplicate, err := chat.SendMessage(newTestMessage(1, senderId, "m-1", "hello"))
+	if err != nil {
+		t.Fatalf("SendMessage() retry error = %v", err)
+	}
+	if !duplicate {
+		t.Error("SendMessage() did not report the retry as a duplicate")
+	}
+	if retried.ID != first.ID {
+		t.Errorf("SendMessage() retry returned message %d, want %d", retried.ID, first.ID)
+	}
+	if repo.saves != 1 {
+		t.Errorf("SendMessage() stored %d messages, want 1", repo.saves)
+	}
+}
+
+func TestSendMessageReturnsMessageOfConcurrentRetry(t *testing.T) {
+	repo := newFakeMessageRepository()
+	chat := NewChatUsecase(nil, repo)
+	senderId := uuid.Must(uuid.NewV4()).String()
+
+	// the retry is not stored yet when looked up, but is by the time the
+	// message is saved
+	var concurrent domain.Message
+	repo.beforeSave = func() {
+		var err error
+		concurrent, err = repo.SaveMessage(newTestMessage(1, senderId, "m-1", "hello"))
+		if err != nil {
+			t.Fatalf("SaveMessage() error = %v", err)
+		}
+	}
+
+	saved, duplicate, err := chat.SendMessage(newTestMessage(1, senderId, "m-1", "hello"))
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if !duplicate {
+		t.Error("SendMessage() did not report the concurrent retry as a duplicate")
+	}
+	if saved.ID != concurrent.ID {
+		t.Errorf("SendMessage() returned message %d, want %d", saved.ID, concurrent.ID)
+	}
+}
+
+func TestSendMessageScopesClientMessageIDsToConversation(t *testing.T) {
+	repo := newFakeMessageRepository()
+	chat := NewChatUsecase(nil, repo)
+	senderId := uuid.Must(uuid.NewV4()).String()
+
+	first, _, err := chat.SendMessage(newTestMessage(1, senderId, "1", "to the first conversation"))
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+
+	second, duplicate, err := chat.SendMessage(newTestMessage(2, senderId, "1", "to the second conversation"))
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if duplicate {
+		t.Error("SendMessage() reported a message of another conversation as a duplicate")
+	}
+	if second.ID == first.ID || second.ConversationID != 2 || second.Message != "to the second conversation" {
+		t.Errorf("SendMessage() returned %+v, want the message sent to the second conversation", second)
+	}
+}
+
+func TestSendMessageWithoutClientMessageIDIsNeverDuplicate(t *testing.T) {
+	repo := newFakeMessageRepository()
+	chat := NewChatUsecase(nil, repo)
+	senderId := uuid.Must(uuid.NewV4()).String()
+
+	for i := 0; i < 2; i++ {
+		_, duplicate, err := chat.SendMessage(newTestMessage(1, senderId, "", "hello"))
+		if err != nil {
+			t.Fatalf("SendMessage() error = %v", err)
+		}
+		if duplicate {
+			t.Error("SendMessage() reported a message without client message ID as a duplicate")
+		}
+	}
+	if repo.saves != 2 {
+		t.Errorf("SendMessage() stored %d messages, want 2", repo.saves)
+	}
+}
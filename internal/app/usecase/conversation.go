@@ -0,0 +1,381 @@
+package usecase
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/tranminhquanq/gomess/internal/app/domain"
+	"github.com/tranminhquanq/gomess/internal/app/domain/repository"
+	"github.com/tranminhquanq/gomess/internal/models"
+)
+
+// MaxGroupParticipants is the number of users a group can have.
+const MaxGroupParticipants = 256
+
+type ConversationUsecase struct {
+	conversationRepository repository.ConversationRepository
+	participantRepository  repository.ParticipantRepository
+	messageRepository      repository.MessageRepository
+}
+
+func NewConversationUsecase(
+	conversationRepository repository.ConversationRepository,
+	participantRepository repository.ParticipantRepository,
+	messageRepository repository.MessageRepository,
+) *ConversationUsecase {
+	return &ConversationUsecase{
+		conversationRepository: conversationRepository,
+		participantRepository:  participantRepository,
+		messageRepository:      messageRepository,
+	}
+}
+
+// CreateConversation stores conversation, whose creator takes part in it, and
+// reports whether it was created. A group conversation needs a title. A single
+// conversation is between the creator and one other user, or the creator
+// alone, and the existing one is returned when there is one.
+func (u *ConversationUsecase) CreateConversation(conversation domain.Conversation) (domain.Conversation, bool, error) {
+	if !conversation.HasParticipant(conversation.CreatorID) {
+		conversation.ParticipantIDs = append([]string{conversation.CreatorID}, conversation.ParticipantIDs...)
+	}
+
+	switch {
+	case conversation.IsSingle():
+		switch len(conversation.ParticipantIDs) {
+		case 1:
+			return u.DirectConversation(conversation.CreatorID, conversation.CreatorID)
+		case 2:
+			return u.DirectConversation(conversation.CreatorID, conversation.ParticipantIDs[1])
+		default:
+			return domain.Conversation{}, false, errors.New("a single conversation must have at most two participants")
+		}
+	case conversation.IsGroup():
+		if conversation.Title == "" {
+			return domain.Conversation{}, false, errors.New("a group conversation must have a title")
+		}
+	default:
+		return domain.Conversation{}, false, errors.Errorf("unsupported conversation type %q", conversation.Type)
+	}
+
+	created, err := u.conversationRepository.CreateConversation(conversation)
+	if err != nil {
+		return domain.Conversation{}, false, err
+	}
+
+	return created, true, nil
+}
+
+// DirectConversation returns the single conversation between the user and
+// peerId, creating it unless it exists, and reports whether it was created.
+// A user can have a conversation with themselves, to keep notes.
+func (u *ConversationUsecase) DirectConversation(userId string, peerId string) (domain.Conversation, bool, error) {
+	conversation := domain.Conversation{
+		CreatorID: userId,
+		Type:      models.ConversationTypeSingle,
+		CreatedAt: time.Now(),
+	}
+
+	return u.conversationRepository.FindOrCreateDirectConversation(conversation, peerId)
+}
+
+// Conversations returns a page of the conversations the user takes part in,
+// latest first.
+func (u *ConversationUsecase) Conversations(userId string, page, limit int) (domain.ListResult[domain.Conversation], error) {
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return domain.ListResult[domain.Conversation]{}, errors.Wrap(err, "invalid user id")
+	}
+
+	return u.conversationRepository.FindConversationsOfUser(id, (page-1)*limit, limit)
+}
+
+// Conversation returns the conversation, provided the user takes part in it.
+// Conversations of others are reported as not found.
+func (u *ConversationUsecase) Conversation(conversationId int64, userId string) (domain.Conversation, error) {
+	conversation, err := u.conversationRepository.FindConversationByID(conversationId)
+	if err != nil {
+		return domain.Conversation{}, err
+	}
+
+	if !conversation.HasParticipant(userId) {
+		return domain.Conversation{}, models.ConversationNotFoundError{}
+	}
+
+	return conversation, nil
+}
+
+// RenameConversation changes the title of a group conversation, which its
+// owners and admins may do.
+func (u *ConversationUsecase) RenameConversation(conversationId int64, userId string, title string) (domain.Conversation, error) {
+	_, role, err := u.group(conversationId, userId)
+	if err != nil {
+		return domain.Conversation{}, err
+	}
+
+	if !domain.CanManageMembers(role) {
+		return domain.Conversation{}, models.PermissionDeniedError{Reason: "Only owners and admins can rename the group"}
+	}
+
+	return u.conversationRepository.UpdateConversationTitle(conversationId, title)
+}
+
+// DeleteConversation deletes the conversation with its messages. Either
+// participant may delete a single conversation, only owners a group one.
+func (u *ConversationUsecase) DeleteConversation(conversationId int64, userId string) (domain.Conversation, error) {
+	conversation, err := u.Conversation(conversationId, userId)
+	if err != nil {
+		return domain.Conversation{}, err
+	}
+
+	if role, _ := conversation.Role(userId); conversation.IsGroup() && role != models.ParticipantRoleOwner {
+		return domain.Conversation{}, models.PermissionDeniedError{Reason: "Only owners can delete the group"}
+	}
+
+	if err := u.conversationRepository.DeleteConversation(conversationId); err != nil {
+		return domain.Conversation{}, err
+	}
+
+	return conversation, nil
+}
+
+// AddParticipants adds users to a group as members, which its owners and
+// admins may do. The users taking part in it already are left out of the
+// change.
+func (u *ConversationUsecase) AddParticipants(conversationId int64, actorId string, userIds []string) (domain.MembershipEvent, error) {
+	conversation, role, err := u.group(conversationId, actorId)
+	if err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	if !domain.CanManageMembers(role) {
+		return domain.MembershipEvent{}, models.PermissionDeniedError{Reason: "Only owners and admins can add participants"}
+	}
+
+	ids := make([]uuid.UUID, 0, len(userIds))
+	for _, userId := range userIds {
+		id, err := uuid.FromString(userId)
+		if err != nil {
+			return domain.MembershipEvent{}, errors.Wrap(err, "invalid user id")
+		}
+		if !conversation.HasParticipant(id.String()) {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(conversation.Participants)+len(ids) > MaxGroupParticipants {
+		return domain.MembershipEvent{}, models.PermissionDeniedError{Reason: fmt.Sprintf("A group cannot have more than %d participants", MaxGroupParticipants)}
+	}
+
+	added, err := u.participantRepository.AddParticipants(conversationId, ids, models.ParticipantRoleMember)
+	if err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	event := domain.MembershipEvent{
+		ConversationID: conversationId,
+		Change:         domain.MembershipAdded,
+		ActorID:        actorId,
+		UserIDs:        uuidStrings(added),
+		Role:           models.ParticipantRoleMember,
+	}
+	if len(added) == 0 {
+		return event, nil
+	}
+
+	return u.recordMembership(event)
+}
+
+// RemoveParticipant removes a participant from a group. Owners may remove
+// admins and members, admins only members.
+func (u *ConversationUsecase) RemoveParticipant(conversationId int64, actorId string, userId string) (domain.MembershipEvent, error) {
+	if _, _, err := u.group(conversationId, actorId); err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	if userId == actorId {
+		return domain.MembershipEvent{}, models.PermissionDeniedError{Reason: "Participants leave a group rather than remove themselves"}
+	}
+
+	aid, err := uuid.FromString(actorId)
+	if err != nil {
+		return domain.MembershipEvent{}, errors.Wrap(err, "invalid user id")
+	}
+
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return domain.MembershipEvent{}, errors.Wrap(err, "invalid user id")
+	}
+
+	// the roles are checked by the repository on the rows it locks, as they
+	// may change concurrently
+	if err := u.participantRepository.RemoveParticipant(conversationId, aid, id); err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	return u.recordMembership(domain.MembershipEvent{
+		ConversationID: conversationId,
+		Change:         domain.MembershipRemoved,
+		ActorID:        actorId,
+		UserIDs:        []string{userId},
+	})
+}
+
+// LeaveConversation takes the user out of a group. When the last owner
+// leaves, the oldest admin, or the oldest member when there is no admin,
+// becomes owner. The group is deleted when the last participant leaves, and
+// the event then carries no message.
+func (u *ConversationUsecase) LeaveConversation(conversationId int64, userId string) (domain.MembershipEvent, error) {
+	if _, _, err := u.group(conversationId, userId); err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return domain.MembershipEvent{}, errors.Wrap(err, "invalid user id")
+	}
+
+	newOwnerId, deleted, err := u.participantRepository.LeaveConversation(conversationId, id)
+	if err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	event := domain.MembershipEvent{
+		ConversationID: conversationId,
+		Change:         domain.MembershipLeft,
+		ActorID:        userId,
+		UserIDs:        []string{userId},
+	}
+	if !newOwnerId.IsNil() {
+		event.NewOwnerID = newOwnerId.String()
+	}
+	if deleted {
+		return event, nil
+	}
+
+	return u.recordMembership(event)
+}
+
+// SetParticipantRole makes a participant of a group an admin or a member,
+// which its owners may do. Owners keep their role until they transfer the
+// ownership.
+func (u *ConversationUsecase) SetParticipantRole(conversationId int64, actorId string, userId string, role models.ParticipantRole) (domain.MembershipEvent, error) {
+	if _, _, err := u.group(conversationId, actorId); err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	if role != models.ParticipantRoleAdmin && role != models.ParticipantRoleMember {
+		return domain.MembershipEvent{}, errors.Errorf("unsupported participant role %q", role)
+	}
+
+	aid, err := uuid.FromString(actorId)
+	if err != nil {
+		return domain.MembershipEvent{}, errors.Wrap(err, "invalid user id")
+	}
+
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return domain.MembershipEvent{}, errors.Wrap(err, "invalid user id")
+	}
+
+	changed, err := u.participantRepository.UpdateParticipantRole(conversationId, aid, id, role)
+	if err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	event := domain.MembershipEvent{
+		ConversationID: conversationId,
+		Change:         domain.MembershipRoleChanged,
+		ActorID:        actorId,
+		UserIDs:        []string{userId},
+		Role:           role,
+	}
+	if !changed {
+		return event, nil
+	}
+
+	return u.recordMembership(event)
+}
+
+// TransferOwnership makes another participant of a group its owner in place
+// of the actor, who stays on as an admin.
+func (u *ConversationUsecase) TransferOwnership(conversationId int64, actorId string, userId string) (domain.MembershipEvent, error) {
+	if _, _, err := u.group(conversationId, actorId); err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	if userId == actorId {
+		return domain.MembershipEvent{}, models.PermissionDeniedError{Reason: "The ownership must be transferred to another participant"}
+	}
+
+	fromId, err := uuid.FromString(actorId)
+	if err != nil {
+		return domain.MembershipEvent{}, errors.Wrap(err, "invalid user id")
+	}
+
+	toId, err := uuid.FromString(userId)
+	if err != nil {
+		return domain.MembershipEvent{}, errors.Wrap(err, "invalid user id")
+	}
+
+	if err := u.participantRepository.TransferOwnership(conversationId, fromId, toId); err != nil {
+		return domain.MembershipEvent{}, err
+	}
+
+	return u.recordMembership(domain.MembershipEvent{
+		ConversationID: conversationId,
+		Change:         domain.MembershipOwnershipTransferred,
+		ActorID:        actorId,
+		UserIDs:        []string{userId},
+		Role:           models.ParticipantRoleOwner,
+	})
+}
+
+// group returns the group conversation the user takes part in and the role
+// of the user in it.
+func (u *ConversationUsecase) group(conversationId int64, userId string) (domain.Conversation, models.ParticipantRole, error) {
+	conversation, err := u.Conversation(conversationId, userId)
+	if err != nil {
+		return domain.Conversation{}, "", err
+	}
+
+	if !conversation.IsGroup() {
+		return domain.Conversation{}, "", models.PermissionDeniedError{Reason: "Single conversations have fixed participants"}
+	}
+
+	role, _ := conversation.Role(userId)
+	return conversation, role, nil
+}
+
+// recordMembership stores the system message recording the change in the
+// timeline of the group, and returns the event carrying it.
+func (u *ConversationUsecase) recordMembership(event domain.MembershipEvent) (domain.MembershipEvent, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return domain.MembershipEvent{}, errors.Wrap(err, "encoding membership event")
+	}
+
+	saved, err := u.messageRepository.SaveMessage(domain.Message{
+		ConversationID: event.ConversationID,
+		SenderID:       event.ActorID,
+		Type:           models.MessageTypeSystem,
+		Message:        string(body),
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		return domain.MembershipEvent{}, err
+	}
+	event.Message = &saved
+
+	return event, nil
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	strs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strs = append(strs, id.String())
+	}
+	return strs
+}
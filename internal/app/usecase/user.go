@@ -1,20 +1,26 @@
 package usecase
 
 import (
-	"context"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
 	"github.com/tranminhquanq/gomess/internal/app/domain"
 	"github.com/tranminhquanq/gomess/internal/app/domain/repository"
 )
 
 type UserUsecase struct {
-	repository repository.UserRepository
+	repository         repository.UserRepository
+	presenceRepository repository.PresenceRepository
 }
 
-func NewUserUsecase(repository repository.UserRepository) *UserUsecase {
+func NewUserUsecase(
+	repository repository.UserRepository,
+	presenceRepository repository.PresenceRepository,
+) *UserUsecase {
 	return &UserUsecase{
-		repository: repository,
+		repository:         repository,
+		presenceRepository: presenceRepository,
 	}
 }
 
@@ -31,11 +37,60 @@ func (u *UserUsecase) PartialUpdateUser(user domain.User) (domain.User, error) {
 	return u.repository.UpdateUser(user)
 }
 
-func (u *UserUsecase) UpdateUserStatus() (interface{}, error) {
-	return nil, nil
+// UpdateUserStatus stores the presence status the user chose.
+func (u *UserUsecase) UpdateUserStatus(userId string, status domain.PresenceStatus) (domain.Presence, error) {
+	if !status.IsSelectable() {
+		return domain.Presence{}, errors.Errorf("unsupported presence status %q", status)
+	}
+
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return domain.Presence{}, errors.Wrap(err, "invalid user id")
+	}
+
+	return u.presenceRepository.SaveStatus(id, status)
+}
+
+// UpdateLastSeen records when the user was last connected.
+func (u *UserUsecase) UpdateLastSeen(userId string, lastSeenAt time.Time) error {
+	id, err := uuid.FromString(userId)
+	if err != nil {
+		return errors.Wrap(err, "invalid user id")
+	}
+
+	return u.presenceRepository.UpdateLastSeen(id, lastSeenAt)
 }
 
-func (u *UserUsecase) GetUserFromToken(ctx context.Context) (domain.User, error) {
-	userId := ctx.Value("userId").(string)
-	return u.repository.FindUserById(uuid.FromStringOrNil(userId))
+// Presences returns the stored presence of every user, in the given order.
+// Users that never chose a status are online when connected.
+func (u *UserUsecase) Presences(userIds []string) ([]domain.Presence, error) {
+	ids := make([]uuid.UUID, 0, len(userIds))
+	for _, userId := range userIds {
+		id, err := uuid.FromString(userId)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid user id")
+		}
+		ids = append(ids, id)
+	}
+
+	stored, err := u.presenceRepository.FindPresences(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[string]domain.Presence, len(stored))
+	for _, presence := range stored {
+		byUser[presence.UserID] = presence
+	}
+
+	presences := make([]domain.Presence, 0, len(ids))
+	for _, id := range ids {
+		presence, ok := byUser[id.String()]
+		if !ok {
+			presence = domain.Presence{UserID: id.String(), Status: domain.PresenceOnline}
+		}
+		presences = append(presences, presence)
+	}
+
+	return presences, nil
 }
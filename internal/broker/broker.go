@@ -0,0 +1,60 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/tranminhquanq/gomess/internal/config"
+)
+
+// Handler is called with the payload of every message published to a topic
+// the broker is subscribed to.
+type Handler func(payload []byte)
+
+// Broker fans out realtime events between gomess instances and keeps track of
+// which instance every client is connected to.
+type Broker interface {
+	// Publish sends payload to every instance subscribed to topic.
+	Publish(ctx context.Context, topic string, payload []byte) error
+
+	// Subscribe calls handler for every message published to topic until
+	// Unsubscribe is called. Subscribing to the same topic again replaces
+	// the handler.
+	Subscribe(ctx context.Context, topic string, handler Handler) error
+
+	// Unsubscribe stops delivering the messages published to topic.
+	Unsubscribe(ctx context.Context, topic string) error
+
+	// RegisterClient records that a connection of clientId is open on
+	// serverId. Every call must be paired with UnregisterClient.
+	RegisterClient(ctx context.Context, clientId, serverId string) error
+
+	// UnregisterClient undoes a previous RegisterClient.
+	UnregisterClient(ctx context.Context, clientId, serverId string) error
+
+	// LookupClient returns the instances clientId has open connections on,
+	// leaving out the instances that stopped without unregistering them.
+	LookupClient(ctx context.Context, clientId string) ([]string, error)
+
+	// LookupClients looks up several clients at once, as LookupClient
+	// does. Clients without open connections are missing from the result.
+	LookupClients(ctx context.Context, clientIds []string) (map[string][]string, error)
+
+	// Close releases the resources held by the broker.
+	Close() error
+}
+
+// Dial creates the broker selected by the configuration.
+func Dial(globalConfig *config.GlobalConfiguration) (Broker, error) {
+	switch globalConfig.Broker.Driver {
+	case config.RedisBroker:
+		b, err := NewRedisBroker(&globalConfig.Broker)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening redis broker")
+		}
+		return b, nil
+
+	default:
+		return NewMemoryBroker(), nil
+	}
+}
@@ -0,0 +1,114 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBroker is a Broker that only reaches the instance it runs in. It is
+// meant for single instance deployments and development.
+type MemoryBroker struct {
+	sync.RWMutex
+
+	handlers map[string]Handler
+	clients  map[string]map[string]int
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		handlers: make(map[string]Handler),
+		clients:  make(map[string]map[string]int),
+	}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.RLock()
+	handler, ok := b.handlers[topic]
+	b.RUnlock()
+
+	if ok {
+		handler(payload)
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic string, handler Handler) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.handlers[topic] = handler
+	return nil
+}
+
+func (b *MemoryBroker) Unsubscribe(ctx context.Context, topic string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.handlers, topic)
+	return nil
+}
+
+func (b *MemoryBroker) RegisterClient(ctx context.Context, clientId, serverId string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	servers, ok := b.clients[clientId]
+	if !ok {
+		servers = make(map[string]int)
+		b.clients[clientId] = servers
+	}
+	servers[serverId]++
+
+	return nil
+}
+
+func (b *MemoryBroker) UnregisterClient(ctx context.Context, clientId, serverId string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	servers, ok := b.clients[clientId]
+	if !ok {
+		return nil
+	}
+
+	servers[serverId]--
+	if servers[serverId] <= 0 {
+		delete(servers, serverId)
+	}
+	if len(servers) == 0 {
+		delete(b.clients, clientId)
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) LookupClient(ctx context.Context, clientId string) ([]string, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	servers := make([]string, 0, len(b.clients[clientId]))
+	for serverId := range b.clients[clientId] {
+		servers = append(servers, serverId)
+	}
+
+	return servers, nil
+}
+
+func (b *MemoryBroker) LookupClients(ctx context.Context, clientIds []string) (map[string][]string, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	servers := make(map[string][]string, len(clientIds))
+	for _, clientId := range clientIds {
+		for serverId := range b.clients[clientId] {
+			servers[clientId] = append(servers[clientId], serverId)
+		}
+	}
+
+	return servers, nil
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
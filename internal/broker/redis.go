@@ -0,0 +1,306 @@
+package broker
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/tranminhquanq/gomess/internal/config"
+)
+
+// unregisterClientScript decrements the number of connections a client has
+// open on a server and forgets the server once it reaches zero, atomically so
+// that a concurrent registration is never lost.
+var unregisterClientScript = redis.NewScript(`
+local n = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+if n <= 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// RedisBroker is a Broker backed by a Redis compatible server, which lets
+// every gomess instance reach the clients connected to the others.
+//
+// Clients are registered per instance, a server ID qualified with an ID of
+// the running process, and every instance keeps a heartbeat key alive for as
+// long as it runs. The registrations of an instance whose heartbeat lapsed
+// are ignored and cleaned up by LookupClient, so that a crashed instance does
+// not keep its clients online, and neither do the registrations it made
+// before restarting under the same server ID.
+type RedisBroker struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	prefix string
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	// processId qualifies the server IDs registered by this process,
+	// servers are the server IDs whose heartbeat it keeps alive.
+	processId    string
+	heartbeatTTL time.Duration
+	serversMu    sync.Mutex
+	servers      map[string]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewRedisBroker(brokerConfig *config.BrokerConfiguration) (*RedisBroker, error) {
+	opts, err := redis.ParseURL(brokerConfig.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing redis url")
+	}
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, "checking redis connection")
+	}
+
+	b := &RedisBroker{
+		client:       client,
+		pubsub:       client.Subscribe(context.Background()),
+		prefix:       brokerConfig.KeyPrefix,
+		handlers:     make(map[string]Handler),
+		processId:    uuid.Must(uuid.NewV4()).String(),
+		heartbeatTTL: brokerConfig.HeartbeatTTL,
+		servers:      make(map[string]struct{}),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	go b.receive()
+	go b.heartbeat()
+
+	return b, nil
+}
+
+func (b *RedisBroker) key(parts ...string) string {
+	return b.prefix + ":" + strings.Join(parts, ":")
+}
+
+// instance returns the field the clients connected to serverId are
+// registered under by this process.
+func (b *RedisBroker) instance(serverId string) string {
+	return serverId + "/" + b.processId
+}
+
+// heartbeatKey returns the key that exists for as long as instance is alive.
+func (b *RedisBroker) heartbeatKey(instance string) string {
+	return b.key("ws_servers", instance)
+}
+
+// heartbeat refreshes the heartbeat keys of the servers this process
+// registered clients on until the broker is closed.
+func (b *RedisBroker) heartbeat() {
+	ticker := time.NewTicker(b.heartbeatTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.serversMu.Lock()
+			pipe := b.client.Pipeline()
+			for serverId := range b.servers {
+				pipe.Set(context.Background(), b.heartbeatKey(b.instance(serverId)), 1, b.heartbeatTTL)
+			}
+			b.serversMu.Unlock()
+
+			if pipe.Len() == 0 {
+				continue
+			}
+			if _, err := pipe.Exec(context.Background()); err != nil {
+				logrus.WithError(err).Warn("unable to refresh redis broker heartbeat")
+			}
+
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// receive dispatches the messages of every subscribed channel to their
+// handler until the broker is closed.
+func (b *RedisBroker) receive() {
+	defer close(b.done)
+
+	channelPrefix := b.key("")
+	for msg := range b.pubsub.Channel() {
+		topic := strings.TrimPrefix(msg.Channel, channelPrefix)
+
+		b.mu.RLock()
+		handler, ok := b.handlers[topic]
+		b.mu.RUnlock()
+
+		if ok {
+			handler([]byte(msg.Payload))
+		}
+	}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	if err := b.client.Publish(ctx, b.key(topic), payload).Err(); err != nil {
+		return errors.Wrapf(err, "publishing to %s", topic)
+	}
+	return nil
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, topic string, handler Handler) error {
+	b.mu.Lock()
+	b.handlers[topic] = handler
+	b.mu.Unlock()
+
+	if err := b.pubsub.Subscribe(ctx, b.key(topic)); err != nil {
+		b.mu.Lock()
+		delete(b.handlers, topic)
+		b.mu.Unlock()
+
+		return errors.Wrapf(err, "subscribing to %s", topic)
+	}
+
+	return nil
+}
+
+func (b *RedisBroker) Unsubscribe(ctx context.Context, topic string) error {
+	b.mu.Lock()
+	delete(b.handlers, topic)
+	b.mu.Unlock()
+
+	if err := b.pubsub.Unsubscribe(ctx, b.key(topic)); err != nil {
+		return errors.Wrapf(err, "unsubscribing from %s", topic)
+	}
+
+	return nil
+}
+
+func (b *RedisBroker) RegisterClient(ctx context.Context, clientId, serverId string) error {
+	b.serversMu.Lock()
+	b.servers[serverId] = struct{}{}
+	b.serversMu.Unlock()
+
+	instance := b.instance(serverId)
+
+	// the heartbeat is set along with the registration so that the client
+	// can be looked up before the next refresh
+	pipe := b.client.TxPipeline()
+	pipe.Set(ctx, b.heartbeatKey(instance), 1, b.heartbeatTTL)
+	pipe.HIncrBy(ctx, b.key("ws_clients", clientId), instance, 1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrapf(err, "registering client %s", clientId)
+	}
+	return nil
+}
+
+func (b *RedisBroker) UnregisterClient(ctx context.Context, clientId, serverId string) error {
+	keys := []string{b.key("ws_clients", clientId)}
+	if err := unregisterClientScript.Run(ctx, b.client, keys, b.instance(serverId)).Err(); err != nil {
+		return errors.Wrapf(err, "unregistering client %s", clientId)
+	}
+	return nil
+}
+
+func (b *RedisBroker) LookupClient(ctx context.Context, clientId string) ([]string, error) {
+	servers, err := b.LookupClients(ctx, []string{clientId})
+	if err != nil {
+		return nil, err
+	}
+	return servers[clientId], nil
+}
+
+func (b *RedisBroker) LookupClients(ctx context.Context, clientIds []string) (map[string][]string, error) {
+	servers := make(map[string][]string, len(clientIds))
+	if len(clientIds) == 0 {
+		return servers, nil
+	}
+
+	pipe := b.client.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, 0, len(clientIds))
+	for _, clientId := range clientIds {
+		cmds = append(cmds, pipe.HKeys(ctx, b.key("ws_clients", clientId)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.Wrap(err, "looking up clients")
+	}
+
+	instances := make([][]string, len(clientIds))
+	var heartbeatKeys []string
+	for i, cmd := range cmds {
+		instances[i] = cmd.Val()
+		for _, instance := range instances[i] {
+			heartbeatKeys = append(heartbeatKeys, b.heartbeatKey(instance))
+		}
+	}
+	if len(heartbeatKeys) == 0 {
+		return servers, nil
+	}
+
+	alive, err := b.client.MGet(ctx, heartbeatKeys...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "looking up clients")
+	}
+
+	pipe = b.client.Pipeline()
+	for i, clientId := range clientIds {
+		var lapsed []string
+		for _, instance := range instances[i] {
+			isAlive := alive[0] != nil
+			alive = alive[1:]
+
+			if !isAlive {
+				lapsed = append(lapsed, instance)
+				continue
+			}
+
+			serverId := instance[:strings.LastIndex(instance, "/")]
+			if !slices.Contains(servers[clientId], serverId) {
+				servers[clientId] = append(servers[clientId], serverId)
+			}
+		}
+
+		if len(lapsed) > 0 {
+			pipe.HDel(ctx, b.key("ws_clients", clientId), lapsed...)
+		}
+	}
+
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			logrus.WithError(err).Warn("unable to forget lapsed redis broker instances")
+		}
+	}
+
+	return servers, nil
+}
+
+func (b *RedisBroker) Close() error {
+	close(b.stop)
+
+	// the clients of this process are not reachable anymore, whether or
+	// not they were unregistered
+	b.serversMu.Lock()
+	heartbeatKeys := make([]string, 0, len(b.servers))
+	for serverId := range b.servers {
+		heartbeatKeys = append(heartbeatKeys, b.heartbeatKey(b.instance(serverId)))
+	}
+	b.serversMu.Unlock()
+
+	if len(heartbeatKeys) > 0 {
+		if err := b.client.Del(context.Background(), heartbeatKeys...).Err(); err != nil {
+			logrus.WithError(err).Warn("unable to remove redis broker heartbeat")
+		}
+	}
+
+	if err := b.pubsub.Close(); err != nil {
+		logrus.WithError(err).Warn("unable to close redis subscriptions")
+	}
+	<-b.done
+
+	return b.client.Close()
+}
@@ -0,0 +1,205 @@
+package broker
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/tranminhquanq/gomess/internal/config"
+)
+
+const testHeartbeatTTL = time.Minute
+
+func newTestRedisBroker(t *testing.T, mr *miniredis.Miniredis) *RedisBroker {
+	t.Helper()
+
+	b, err := NewRedisBroker(&config.BrokerConfiguration{
+		Driver:       config.RedisBroker,
+		URL:          "redis://" + mr.Addr(),
+		KeyPrefix:    "gomess",
+		HeartbeatTTL: testHeartbeatTTL,
+	})
+	if err != nil {
+		t.Fatalf("NewRedisBroker() error = %v", err)
+	}
+
+	return b
+}
+
+func lookupServers(t *testing.T, b *RedisBroker, clientId string) []string {
+	t.Helper()
+
+	servers, err := b.LookupClient(context.Background(), clientId)
+	if err != nil {
+		t.Fatalf("LookupClient() error = %v", err)
+	}
+	slices.Sort(servers)
+
+	return servers
+}
+
+func TestRedisBrokerCountsRegistrations(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b := newTestRedisBroker(t, mr)
+	defer b.Close()
+
+	ctx := context.Background()
+	for _, serverId := range []string{"server-1", "server-1", "server-2"} {
+		if err := b.RegisterClient(ctx, "user-1", serverId); err != nil {
+			t.Fatalf("RegisterClient() error = %v", err)
+		}
+	}
+
+	if got := lookupServers(t, b, "user-1"); !slices.Equal(got, []string{"server-1", "server-2"}) {
+		t.Errorf("LookupClient() = %v, want [server-1 server-2]", got)
+	}
+
+	// one of the two connections to server-1 is left
+	if err := b.UnregisterClient(ctx, "user-1", "server-1"); err != nil {
+		t.Fatalf("UnregisterClient() error = %v", err)
+	}
+	if got := lookupServers(t, b, "user-1"); !slices.Equal(got, []string{"server-1", "server-2"}) {
+		t.Errorf("LookupClient() after an unregistration = %v, want [server-1 server-2]", got)
+	}
+
+	if err := b.UnregisterClient(ctx, "user-1", "server-1"); err != nil {
+		t.Fatalf("UnregisterClient() error = %v", err)
+	}
+	if got := lookupServers(t, b, "user-1"); !slices.Equal(got, []string{"server-2"}) {
+		t.Errorf("LookupClient() after the last unregistration = %v, want [server-2]", got)
+	}
+
+	fields, err := mr.HKeys("gomess:ws_clients:user-1")
+	if err != nil {
+		t.Fatalf("HKeys() error = %v", err)
+	}
+	if len(fields) != 1 {
+		t.Errorf("ws_clients hash has fields %v, want only the instance of server-2", fields)
+	}
+}
+
+func TestRedisBrokerLookupClients(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b := newTestRedisBroker(t, mr)
+	defer b.Close()
+
+	ctx := context.Background()
+	if err := b.RegisterClient(ctx, "user-1", "server-1"); err != nil {
+		t.Fatalf("RegisterClient() error = %v", err)
+	}
+	if err := b.RegisterClient(ctx, "user-2", "server-2"); err != nil {
+		t.Fatalf("RegisterClient() error = %v", err)
+	}
+
+	servers, err := b.LookupClients(ctx, []string{"user-1", "user-2", "user-3"})
+	if err != nil {
+		t.Fatalf("LookupClients() error = %v", err)
+	}
+
+	if got := servers["user-1"]; !slices.Equal(got, []string{"server-1"}) {
+		t.Errorf("LookupClients() of user-1 = %v, want [server-1]", got)
+	}
+	if got := servers["user-2"]; !slices.Equal(got, []string{"server-2"}) {
+		t.Errorf("LookupClients() of user-2 = %v, want [server-2]", got)
+	}
+	if got := servers["user-3"]; len(got) != 0 {
+		t.Errorf("LookupClients() of user-3 = %v, want none", got)
+	}
+}
+
+func TestRedisBrokerForgetsInstancesWithLapsedHeartbeat(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b := newTestRedisBroker(t, mr)
+	defer b.Close()
+
+	ctx := context.Background()
+	if err := b.RegisterClient(ctx, "user-1", "server-1"); err != nil {
+		t.Fatalf("RegisterClient() error = %v", err)
+	}
+
+	// the instance stops refreshing its heartbeat, as if it crashed
+	mr.FastForward(testHeartbeatTTL + time.Second)
+
+	if got := lookupServers(t, b, "user-1"); len(got) != 0 {
+		t.Errorf("LookupClient() after the heartbeat lapsed = %v, want none", got)
+	}
+	if mr.Exists("gomess:ws_clients:user-1") {
+		t.Error("LookupClient() kept the registration of the lapsed instance")
+	}
+}
+
+func TestRedisBrokerCloseTakesItsClientsOffline(t *testing.T) {
+	mr := miniredis.RunT(t)
+	closing := newTestRedisBroker(t, mr)
+	b := newTestRedisBroker(t, mr)
+	defer b.Close()
+
+	ctx := context.Background()
+	if err := closing.RegisterClient(ctx, "user-1", "server-1"); err != nil {
+		t.Fatalf("RegisterClient() error = %v", err)
+	}
+	if got := lookupServers(t, b, "user-1"); !slices.Equal(got, []string{"server-1"}) {
+		t.Fatalf("LookupClient() = %v, want [server-1]", got)
+	}
+
+	// the process stops, e.g. to restart under the same server ID, without
+	// unregistering its clients
+	if err := closing.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if got := lookupServers(t, b, "user-1"); len(got) != 0 {
+		t.Errorf("LookupClient() after Close() = %v, want none", got)
+	}
+}
+
+func TestRedisBrokerResubscribesAfterReconnect(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b := newTestRedisBroker(t, mr)
+	defer b.Close()
+
+	received := make(chan string, 16)
+	ctx := context.Background()
+	if err := b.Subscribe(ctx, "events", func(payload []byte) {
+		received <- string(payload)
+	}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	// publishes until a message gets through, as the subscription may not
+	// be in place yet
+	publishUntilReceived := func(payload string) {
+		t.Helper()
+
+		deadline := time.After(10 * time.Second)
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			if err := b.Publish(ctx, "events", []byte(payload)); err != nil {
+				t.Logf("Publish() error = %v", err)
+			}
+
+			select {
+			case got := <-received:
+				if got == payload {
+					return
+				}
+			case <-ticker.C:
+			case <-deadline:
+				t.Fatalf("message %q was not received", payload)
+			}
+		}
+	}
+
+	publishUntilReceived("before")
+
+	mr.Close()
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("Restart() error = %v", err)
+	}
+
+	publishUntilReceived("after")
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+type BrokerDriver = string
+
+const (
+	MemoryBroker BrokerDriver = "memory"
+	RedisBroker  BrokerDriver = "redis"
+)
+
+// BrokerConfiguration holds the configuration of the pub/sub broker used to
+// fan out realtime events between gomess instances.
+type BrokerConfiguration struct {
+	// Driver is either memory, which only reaches clients connected to the
+	// same instance, or redis, which reaches clients on every instance.
+	Driver BrokerDriver `json:"driver" default:"memory"`
+
+	// URL is the address of the Redis compatible server, e.g.
+	// redis://localhost:6379/0. Only used by the redis driver.
+	URL string `json:"url"`
+
+	// KeyPrefix is prepended to every channel and key the broker uses.
+	KeyPrefix string `json:"key_prefix" split_words:"true" default:"gomess"`
+
+	// HeartbeatTTL is how long an instance is considered alive after its
+	// last heartbeat. Clients registered on an instance whose heartbeat
+	// lapsed, e.g. because it crashed, are no longer looked up. Only used
+	// by the redis driver.
+	HeartbeatTTL time.Duration `json:"heartbeat_ttl" split_words:"true" default:"30s"`
+}
+
+func (c *BrokerConfiguration) Validate() error {
+	switch c.Driver {
+	case MemoryBroker:
+		return nil
+
+	case RedisBroker:
+		if c.URL == "" {
+			return fmt.Errorf("broker: url is required for the %q driver", c.Driver)
+		}
+		if c.HeartbeatTTL <= 0 {
+			return fmt.Errorf("broker: heartbeat_ttl must be positive")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("broker: unsupported driver %q", c.Driver)
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -41,6 +42,8 @@ type APIConfiguration struct {
 	RequestIDHeader    string        `envconfig:"REQUEST_ID_HEADER"`
 	ExternalURL        string        `json:"external_url" envconfig:"API_EXTERNAL_URL" required:"true"`
 	MaxRequestDuration time.Duration `json:"max_request_duration" split_words:"true" default:"10s"`
+
+	WebSocket WebSocketConfiguration `json:"websocket" envconfig:"WS"`
 }
 
 func (a *APIConfiguration) Validate() error {
@@ -49,11 +52,17 @@ func (a *APIConfiguration) Validate() error {
 		return err
 	}
 
-	return nil
+	return a.WebSocket.Validate()
 }
 
 type CORSConfiguration struct {
 	AllowedHeaders []string `json:"allowed_headers" split_words:"true"`
+
+	// AllowedOrigins are glob patterns of the origins, besides the site
+	// URL and the URI allow list, that browsers may call the API and open
+	// WebSocket connections from, e.g. https://*.example.com.
+	AllowedOrigins    []string `json:"allowed_origins" split_words:"true"`
+	AllowedOriginsMap map[string]glob.Glob
 }
 
 func (c *CORSConfiguration) AllAllowedHeaders(defaults []string) []string {
@@ -111,11 +120,13 @@ type JWTConfiguration struct {
 
 // GlobalConfiguration holds all the configuration that applies to all instances.
 type GlobalConfiguration struct {
-	API     APIConfiguration
-	CORS    CORSConfiguration
-	DB      DBConfiguration
-	Tracing TracingConfig
-	Metrics MetricsConfig
+	API      APIConfiguration
+	CORS     CORSConfiguration
+	DB       DBConfiguration
+	Broker   BrokerConfiguration
+	Messages MessagesConfiguration
+	Tracing  TracingConfig
+	Metrics  MetricsConfig
 
 	SiteURL         string   `json:"site_url" split_words:"true" required:"true"`
 	URIAllowList    []string `json:"uri_allow_list" split_words:"true"`
@@ -125,9 +136,34 @@ type GlobalConfiguration struct {
 
 // ApplyDefaults sets defaults for a GlobalConfiguration
 func (config *GlobalConfiguration) ApplyDefaults() error {
+	uriAllowListMap, err := compileGlobs(config.URIAllowList)
+	if err != nil {
+		return fmt.Errorf("uri_allow_list: %w", err)
+	}
+	config.URIAllowListMap = uriAllowListMap
+
+	allowedOriginsMap, err := compileGlobs(config.CORS.AllowedOrigins)
+	if err != nil {
+		return fmt.Errorf("cors allowed_origins: %w", err)
+	}
+	config.CORS.AllowedOriginsMap = allowedOriginsMap
+
 	return nil
 }
 
+// compileGlobs compiles URL patterns where * does not cross dots and slashes.
+func compileGlobs(patterns []string) (map[string]glob.Glob, error) {
+	globs := make(map[string]glob.Glob, len(patterns))
+	for _, pattern := range patterns {
+		g, err := glob.Compile(pattern, '.', '/')
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		globs[pattern] = g
+	}
+	return globs, nil
+}
+
 // Validate validates all of configuration.
 func (c *GlobalConfiguration) Validate() error {
 	validatables := []interface {
@@ -135,6 +171,8 @@ func (c *GlobalConfiguration) Validate() error {
 	}{
 		&c.API,
 		&c.DB,
+		&c.Broker,
+		&c.Messages,
 		&c.Tracing,
 		&c.Metrics,
 	}
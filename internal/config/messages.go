@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// MessagesConfiguration holds the configuration of the messages users send.
+type MessagesConfiguration struct {
+	// EditWindow is how long after sending a message its sender may edit
+	// it. Zero lets messages be edited at any time.
+	EditWindow time.Duration `json:"edit_window" split_words:"true" default:"15m"`
+}
+
+func (c *MessagesConfiguration) Validate() error {
+	if c.EditWindow < 0 {
+		return fmt.Errorf("messages: edit_window must not be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+type WsOverflowPolicy = string
+
+const (
+	// WsOverflowDropOldest drops the oldest queued message to make room
+	// for a new one when the outbound queue of a connection is full.
+	WsOverflowDropOldest WsOverflowPolicy = "drop_oldest"
+
+	// WsOverflowDisconnect closes the connection of a client that does not
+	// keep up with its outbound queue.
+	WsOverflowDisconnect WsOverflowPolicy = "disconnect"
+)
+
+// WebSocketConfiguration holds the configuration of the realtime WebSocket
+// connections.
+type WebSocketConfiguration struct {
+	// SendBufferSize is the number of outbound messages queued per
+	// connection before OverflowPolicy applies.
+	SendBufferSize int              `json:"send_buffer_size" split_words:"true" default:"256"`
+	OverflowPolicy WsOverflowPolicy `json:"overflow_policy" split_words:"true" default:"drop_oldest"`
+
+	// WriteTimeout is how long a single write may block before the
+	// connection is considered stalled and closed.
+	WriteTimeout time.Duration `json:"write_timeout" split_words:"true" default:"10s"`
+
+	// PingInterval is how often a ping is sent to every connection, and
+	// PongTimeout how long to wait past an interval for the pong before
+	// the peer is considered dead.
+	PingInterval time.Duration `json:"ping_interval" split_words:"true" default:"30s"`
+	PongTimeout  time.Duration `json:"pong_timeout" split_words:"true" default:"10s"`
+
+	// MaxIdle closes connections that have not sent any message for
+	// longer than it, pongs excluded, so it also closes healthy clients
+	// that only receive, and every event stream. Dead peers are found by
+	// PongTimeout already. Zero, the default, disables idle reaping.
+	MaxIdle time.Duration `json:"max_idle" split_words:"true" default:"0"`
+
+	// Compression negotiates permessage-deflate with the clients that
+	// support it. Messages shorter than CompressionThreshold bytes are
+	// sent uncompressed as deflating them costs more than it saves.
+	Compression          bool `json:"compression" default:"true"`
+	CompressionThreshold int  `json:"compression_threshold" split_words:"true" default:"1024"`
+
+	// ReplayLimit is the number of missed messages a resuming client gets
+	// replayed per conversation, or in total when resuming from a global
+	// cursor. Past it the client is told to resync instead.
+	ReplayLimit int `json:"replay_limit" split_words:"true" default:"500"`
+
+	// TypingTimeout is how long a typing indicator lasts unless the client
+	// refreshes it, TypingThrottle how often at most it is broadcast for a
+	// user in a conversation.
+	TypingTimeout  time.Duration `json:"typing_timeout" split_words:"true" default:"6s"`
+	TypingThrottle time.Duration `json:"typing_throttle" split_words:"true" default:"2s"`
+
+	// DrainTimeout is how long a shutting down server waits for its
+	// connections to close. Clients are told to reconnect after a random
+	// delay of up to ReconnectJitter so that they spread over the
+	// remaining instances.
+	DrainTimeout    time.Duration `json:"drain_timeout" split_words:"true" default:"30s"`
+	ReconnectJitter time.Duration `json:"reconnect_jitter" split_words:"true" default:"10s"`
+
+	// MaxMessageSize is the largest message a client may send, in bytes.
+	// Larger ones close the connection.
+	MaxMessageSize int64 `json:"max_message_size" split_words:"true" default:"65536"`
+
+	// ConnectionRateLimits and UserRateLimits cap how many messages per
+	// second of each action a connection, and a user across its
+	// connections to an instance, may send, e.g. send_message:10. The *
+	// entry applies to the other actions and zero lifts the limit. Bursts
+	// of up to RateLimitBurst worth of messages are let through.
+	ConnectionRateLimits map[string]float64 `json:"connection_rate_limits" split_words:"true" default:"*:20,send_message:10,typing_start:2,typing_stop:2"`
+	UserRateLimits       map[string]float64 `json:"user_rate_limits" split_words:"true" default:"*:40,send_message:20,typing_start:4,typing_stop:4"`
+	RateLimitBurst       time.Duration      `json:"rate_limit_burst" split_words:"true" default:"2s"`
+
+	// MaxRateLimitViolations is how many rate limited messages per minute
+	// a connection may send before it is closed. Zero never closes it.
+	MaxRateLimitViolations int `json:"max_rate_limit_violations" split_words:"true" default:"20"`
+
+	// PublishTimeout is how long publishing an event waits for the
+	// instances the recipients are connected to to report its delivery.
+	PublishTimeout time.Duration `json:"publish_timeout" split_words:"true" default:"2s"`
+}
+
+func (c *WebSocketConfiguration) Validate() error {
+	if c.SendBufferSize <= 0 {
+		return fmt.Errorf("websocket: send_buffer_size must be positive")
+	}
+
+	switch c.OverflowPolicy {
+	case WsOverflowDropOldest, WsOverflowDisconnect:
+	default:
+		return fmt.Errorf("websocket: unsupported overflow_policy %q", c.OverflowPolicy)
+	}
+
+	if c.WriteTimeout <= 0 {
+		return fmt.Errorf("websocket: write_timeout must be positive")
+	}
+
+	if c.PingInterval <= 0 || c.PongTimeout <= 0 {
+		return fmt.Errorf("websocket: ping_interval and pong_timeout must be positive")
+	}
+
+	if c.MaxIdle < 0 {
+		return fmt.Errorf("websocket: max_idle must not be negative")
+	}
+
+	if c.CompressionThreshold < 0 {
+		return fmt.Errorf("websocket: compression_threshold must not be negative")
+	}
+
+	if c.ReplayLimit <= 0 {
+		return fmt.Errorf("websocket: replay_limit must be positive")
+	}
+
+	if c.TypingTimeout <= 0 || c.TypingThrottle < 0 {
+		return fmt.Errorf("websocket: typing_timeout must be positive and typing_throttle must not be negative")
+	}
+
+	if c.MaxMessageSize <= 0 {
+		return fmt.Errorf("websocket: max_message_size must be positive")
+	}
+
+	if c.RateLimitBurst < 0 || c.MaxRateLimitViolations < 0 {
+		return fmt.Errorf("websocket: rate_limit_burst and max_rate_limit_violations must not be negative")
+	}
+
+	if c.PublishTimeout <= 0 {
+		return fmt.Errorf("websocket: publish_timeout must be positive")
+	}
+
+	if c.DrainTimeout < 0 || c.ReconnectJitter < 0 {
+		return fmt.Errorf("websocket: drain_timeout and reconnect_jitter must not be negative")
+	}
+
+	return nil
+}
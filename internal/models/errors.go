@@ -1,9 +1,33 @@
 package models
 
+func IsDuplicateMessageError(err error) bool {
+	switch err.(type) {
+	case DuplicateMessageError, *DuplicateMessageError:
+		return true
+	default:
+		return false
+	}
+}
+
+func IsPermissionDeniedError(err error) bool {
+	switch err.(type) {
+	case PermissionDeniedError, *PermissionDeniedError:
+		return true
+	default:
+		return false
+	}
+}
+
 func IsNotFoundError(err error) bool {
 	switch err.(type) {
 	case UserNotFoundError, *UserNotFoundError:
 		return true
+	case ConversationNotFoundError, *ConversationNotFoundError:
+		return true
+	case ParticipantNotFoundError, *ParticipantNotFoundError:
+		return true
+	case MessageNotFoundError, *MessageNotFoundError:
+		return true
 	default:
 		return false
 	}
@@ -15,3 +39,46 @@ type UserNotFoundError struct{}
 func (e UserNotFoundError) Error() string {
 	return "User not found"
 }
+
+// ConversationNotFoundError represents when a conversation is not found.
+type ConversationNotFoundError struct{}
+
+func (e ConversationNotFoundError) Error() string {
+	return "Conversation not found"
+}
+
+// ParticipantNotFoundError represents when a user does not take part in a
+// conversation.
+type ParticipantNotFoundError struct{}
+
+func (e ParticipantNotFoundError) Error() string {
+	return "Participant not found"
+}
+
+// MessageNotFoundError represents when a message is not found.
+type MessageNotFoundError struct{}
+
+func (e MessageNotFoundError) Error() string {
+	return "Message not found"
+}
+
+// DuplicateMessageError represents when a sender already sent a message with
+// the same client message ID.
+type DuplicateMessageError struct{}
+
+func (e DuplicateMessageError) Error() string {
+	return "Message already sent"
+}
+
+// PermissionDeniedError represents when a user may not do something to a
+// conversation they take part in.
+type PermissionDeniedError struct {
+	Reason string
+}
+
+func (e PermissionDeniedError) Error() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	return "Permission denied"
+}
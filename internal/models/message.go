@@ -1,16 +1,25 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/tranminhquanq/gomess/internal/storage"
+)
 
 type MessageType string
 type ConversationType string
 type AttachmentType string
+type ParticipantRole string
 
 const (
 	MessageTypeText  MessageType = "text"
 	MessageTypeImage MessageType = "image"
 	MessageTypeVideo MessageType = "video"
 	MessageTypeFile  MessageType = "file"
+	// MessageTypeSystem records a change to the conversation in its
+	// timeline, the message is the JSON encoded change.
+	MessageTypeSystem MessageType = "system"
 
 	ConversationTypeSingle ConversationType = "single"
 	ConversationTypeGroup  ConversationType = "group"
@@ -18,32 +27,53 @@ const (
 	AttachmentTypeImage AttachmentType = "image"
 	AttachmentTypeVideo AttachmentType = "video"
 	AttachmentTypeFile  AttachmentType = "file"
+
+	ParticipantRoleOwner  ParticipantRole = "owner"
+	ParticipantRoleAdmin  ParticipantRole = "admin"
+	ParticipantRoleMember ParticipantRole = "member"
 )
 
 type Message struct {
-	ID             int64       `json:"id" db:"id"`
-	ConversationID int64       `json:"conversation_id" db:"conversation_id"`
-	SenderID       int64       `json:"sender_id" db:"sender_id"`
-	Type           MessageType `json:"type" db:"type"`
-	Message        string      `json:"message" db:"message"`
-	CreatedAt      time.Time   `json:"created_at" db:"created_at"`
-	UpdatedAt      *time.Time  `json:"updated_at" db:"updated_at"`
+	ID              int64              `json:"id" db:"id"`
+	ConversationID  int64              `json:"conversation_id" db:"conversation_id"`
+	SenderID        uuid.UUID          `json:"sender_id" db:"sender_id"`
+	ClientMessageID storage.NullString `json:"client_message_id" db:"client_message_id"` // ID the sender generated, to deduplicate retries
+	Seq             int64              `json:"seq" db:"seq"`                             // Position of the message in its conversation
+	StreamSeq       int64              `json:"stream_seq" db:"stream_seq"`               // Position of the message among every message, in commit order
+	Type            MessageType        `json:"type" db:"type"`
+	Message         string             `json:"message" db:"message"`
+	CreatedAt       time.Time          `json:"created_at" db:"created_at"`
+	UpdatedAt       *time.Time         `json:"updated_at" db:"updated_at"`
 }
 
 func (u *Message) TableName() string {
 	return "messages"
 }
 
+type MessageRevision struct {
+	ID         int64     `json:"id" db:"id"`
+	MessageID  int64     `json:"message_id" db:"message_id"`
+	Message    string    `json:"message" db:"message"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`   // When the text was written
+	ReplacedAt time.Time `json:"replaced_at" db:"replaced_at"` // When an edit replaced it
+}
+
+func (u *MessageRevision) TableName() string {
+	return "message_revisions"
+}
+
 type Conversation struct {
-	ID        int64            `json:"id" db:"id"`
-	CreatorID int64            `json:"creator_id" db:"creator_id"`
-	Title     string           `json:"title" db:"title"`
-	Type      ConversationType `json:"type" db:"type"`
-	CreatedAt time.Time        `json:"created_at" db:"created_at"`
-	UpdatedAt *time.Time       `json:"updated_at" db:"updated_at"`
+	ID        int64              `json:"id" db:"id"`
+	CreatorID uuid.UUID          `json:"creator_id" db:"creator_id"`
+	Title     string             `json:"title" db:"title"`
+	Type      ConversationType   `json:"type" db:"type"`
+	LastSeq   int64              `json:"last_seq" db:"last_seq"` // Sequence number of the latest message
+	DMKey     storage.NullString `json:"dm_key" db:"dm_key"`     // Unordered pair of the participants of single conversations
+	CreatedAt time.Time          `json:"created_at" db:"created_at"`
+	UpdatedAt *time.Time         `json:"updated_at" db:"updated_at"`
 }
 
-func (c *Conversation) IsCreator(userID int64) bool {
+func (c *Conversation) IsCreator(userID uuid.UUID) bool {
 	return c.CreatorID == userID
 }
 
@@ -60,10 +90,13 @@ func (u *Conversation) TableName() string {
 }
 
 type Participant struct {
-	ID             int64     `json:"id" db:"id"`
-	ConversationID int64     `json:"conversation_id" db:"conversation_id"`
-	UserID         int64     `json:"user_id" db:"user_id"`
-	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	ID             int64           `json:"id" db:"id"`
+	ConversationID int64           `json:"conversation_id" db:"conversation_id"`
+	UserID         uuid.UUID       `json:"user_id" db:"user_id"`
+	Role           ParticipantRole `json:"role" db:"role"`
+	DeliveredSeq   int64           `json:"delivered_seq" db:"delivered_seq"` // Sequence number up to which the user acknowledged delivery
+	ReadSeq        int64           `json:"read_seq" db:"read_seq"`           // Sequence number up to which the user read the conversation
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
 }
 
 func (u *Participant) TableName() string {
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+type Presence struct {
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	Status     string     `json:"status" db:"status"`
+	LastSeenAt *time.Time `json:"last_seen_at" db:"last_seen_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+func (p *Presence) TableName() string {
+	return "presences"
+}
@@ -0,0 +1,39 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Notification is an event published to a user that no live connection
+// received.
+type Notification struct {
+	UserID string          `json:"user_id"`
+	Event  string          `json:"event"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// Notifier hands the notifications of offline users over to the notification
+// pipeline, e.g. push notifications or emails.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// LogNotifier is a Notifier that only logs the notifications, for deployments
+// without a notification pipeline.
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	logrus.WithFields(logrus.Fields{
+		"user_id": notification.UserID,
+		"event":   notification.Event,
+	}).Info("Notifying offline user")
+
+	return nil
+}
@@ -79,6 +79,18 @@ func (w *interceptingResponseWriter) Header() http.Header {
 	return w.writer.Header()
 }
 
+// Flush sends the response written so far, for streaming endpoints.
+func (w *interceptingResponseWriter) Flush() {
+	if flusher, ok := w.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *interceptingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.writer
+}
+
 // countStatusCodesSafely counts the number of HTTP status codes per route that
 // occurred while Gomess was running. If it is not able to identify the route
 // via chi.RouteContext(ctx).RoutePattern() it counts with a noroute attribute.
@@ -79,6 +79,37 @@ func IsRedirectURLValid(config *config.GlobalConfiguration, redirectURL string)
 	return false
 }
 
+// IsOriginAllowed reports whether a browser may call the API from origin, the
+// value of an Origin header. The site URL, the URI allow list and the CORS
+// allowed origins are accepted.
+func IsOriginAllowed(config *config.GlobalConfiguration, origin string) bool {
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Scheme == "" || originURL.Host == "" {
+		return false
+	}
+
+	base, err := url.Parse(config.SiteURL)
+	if err == nil && base.Scheme == originURL.Scheme && base.Host == originURL.Host {
+		return true
+	}
+
+	for _, pattern := range config.CORS.AllowedOriginsMap {
+		if pattern.Match(origin) {
+			return true
+		}
+	}
+
+	// URI allow list patterns are meant for URLs, so an origin matches when
+	// its root URL does
+	for _, pattern := range config.URIAllowListMap {
+		if pattern.Match(origin) || pattern.Match(origin+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func GetReferrer(r *http.Request, config *config.GlobalConfiguration) string {
 	// try get redirect url from query or post data first
 	reqref := getRedirectTo(r)
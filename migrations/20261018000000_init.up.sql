@@ -0,0 +1,42 @@
+-- conversations, participants, messages and attachments of the chat
+
+CREATE TABLE IF NOT EXISTS conversations (
+	id bigserial PRIMARY KEY,
+	creator_id uuid NOT NULL,
+	title varchar(255) NOT NULL DEFAULT '',
+	type varchar(16) NOT NULL CHECK (type IN ('single', 'group')),
+	created_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS participants (
+	id bigserial PRIMARY KEY,
+	conversation_id bigint NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+	user_id uuid NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	UNIQUE (conversation_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS participants_user_id_idx ON participants (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id bigserial PRIMARY KEY,
+	conversation_id bigint NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+	sender_id uuid NOT NULL,
+	type varchar(16) NOT NULL DEFAULT 'text',
+	message text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS messages_conversation_id_idx ON messages (conversation_id);
+
+CREATE TABLE IF NOT EXISTS attachments (
+	id bigserial PRIMARY KEY,
+	message_id bigint NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	type varchar(16) NOT NULL,
+	url text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS attachments_message_id_idx ON attachments (message_id);
@@ -0,0 +1,22 @@
+-- client message ids deduplicate retried sends, per conversation sequence
+-- numbers order messages and track how far each participant has received them
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS last_seq bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_message_id varchar(255);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq bigint NOT NULL DEFAULT 0;
+
+UPDATE messages m SET seq = numbered.seq
+FROM (
+	SELECT id, row_number() OVER (PARTITION BY conversation_id ORDER BY id) AS seq
+	FROM messages
+) numbered
+WHERE m.id = numbered.id;
+
+UPDATE conversations c SET last_seq = coalesce((SELECT max(seq) FROM messages WHERE conversation_id = c.id), 0);
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_sender_id_client_message_id_key ON messages (sender_id, client_message_id);
+CREATE UNIQUE INDEX IF NOT EXISTS messages_conversation_id_seq_key ON messages (conversation_id, seq);
+DROP INDEX IF EXISTS messages_conversation_id_idx;
+
+ALTER TABLE participants ADD COLUMN IF NOT EXISTS delivered_seq bigint NOT NULL DEFAULT 0;
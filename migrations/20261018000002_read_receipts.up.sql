@@ -0,0 +1,4 @@
+-- sequence number up to which each participant read the conversation, it
+-- never exceeds delivered_seq
+
+ALTER TABLE participants ADD COLUMN IF NOT EXISTS read_seq bigint NOT NULL DEFAULT 0;
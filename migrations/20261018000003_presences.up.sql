@@ -0,0 +1,8 @@
+-- presence status chosen by each user and when they were last connected
+
+CREATE TABLE IF NOT EXISTS presences (
+	user_id uuid PRIMARY KEY,
+	status varchar(16) NOT NULL DEFAULT 'online' CHECK (status IN ('online', 'away', 'dnd', 'invisible')),
+	last_seen_at timestamptz,
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,21 @@
+-- single conversations are keyed on the unordered pair of their participants,
+-- so that there is at most one between two users, or for a user alone
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS dm_key varchar(73);
+
+-- existing duplicates keep their messages but only the oldest one is keyed
+UPDATE conversations c SET dm_key = keyed.dm_key
+FROM (
+	SELECT DISTINCT ON (dm_key) conversation_id, dm_key
+	FROM (
+		SELECT p.conversation_id, min(p.user_id::text) || ':' || max(p.user_id::text) AS dm_key
+		FROM participants p
+		JOIN conversations c ON c.id = p.conversation_id
+		WHERE c.type = 'single'
+		GROUP BY p.conversation_id
+	) pairs
+	ORDER BY dm_key, conversation_id
+) keyed
+WHERE c.id = keyed.conversation_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS conversations_dm_key_key ON conversations (dm_key);
@@ -0,0 +1,22 @@
+-- participants of groups are owners, admins or members, the creators of the
+-- existing groups own them
+
+ALTER TABLE participants ADD COLUMN IF NOT EXISTS role varchar(16) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member'));
+
+UPDATE participants p SET role = 'owner'
+FROM conversations c
+WHERE c.id = p.conversation_id AND c.type = 'group' AND p.user_id = c.creator_id;
+
+-- groups whose creator left are owned by their oldest participant
+UPDATE participants p SET role = 'owner'
+FROM (
+	SELECT DISTINCT ON (p.conversation_id) p.id
+	FROM participants p
+	JOIN conversations c ON c.id = p.conversation_id
+	WHERE c.type = 'group' AND NOT EXISTS (
+		SELECT 1 FROM participants owner
+		WHERE owner.conversation_id = p.conversation_id AND owner.role = 'owner'
+	)
+	ORDER BY p.conversation_id, p.created_at, p.id
+) oldest
+WHERE p.id = oldest.id;
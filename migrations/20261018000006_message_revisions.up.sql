@@ -0,0 +1,11 @@
+-- every text a message had before it was edited
+
+CREATE TABLE IF NOT EXISTS message_revisions (
+	id bigserial PRIMARY KEY,
+	message_id bigint NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	message text NOT NULL,
+	created_at timestamptz NOT NULL, -- when the text was written
+	replaced_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS message_revisions_message_id_idx ON message_revisions (message_id, id);
@@ -0,0 +1,5 @@
+-- client message ids deduplicate the retries of a send to a conversation, a
+-- client may use the same id in another conversation
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_conversation_id_sender_id_client_message_id_key ON messages (conversation_id, sender_id, client_message_id);
+DROP INDEX IF EXISTS messages_sender_id_client_message_id_key;
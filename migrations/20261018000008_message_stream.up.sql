@@ -0,0 +1,19 @@
+-- stream sequence numbers order the messages of every conversation by when
+-- they were committed, which ids do not as they are taken on insert. The
+-- single row of message_stream hands them out, its lock is held from the
+-- insert of a message until the commit so that they follow the commit order
+
+CREATE TABLE IF NOT EXISTS message_stream (
+	id boolean PRIMARY KEY DEFAULT true CHECK (id),
+	last_seq bigint NOT NULL
+);
+
+INSERT INTO message_stream (last_seq)
+SELECT coalesce(max(id), 0) FROM messages
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS stream_seq bigint;
+UPDATE messages SET stream_seq = id WHERE stream_seq IS NULL;
+ALTER TABLE messages ALTER COLUMN stream_seq SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS messages_stream_seq_key ON messages (stream_seq);